@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -37,6 +39,19 @@ func encodeBase62(id UrlId) string {
 	return string(shortUrl)
 }
 
+// isShortUrl reports whether s has the shape of a code produced by encodeBase62
+func isShortUrl(s string) bool {
+	if len(s) != shortURLLen {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(base62Chars, s[i]) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
 func decodeBase62(shortUrl string) UrlId {
 	firstWord := strings.Index(base62Chars, string(shortUrl[0]))*62 + strings.Index(base62Chars, string(shortUrl[1]))
 	secondWord := strings.Index(base62Chars, string(shortUrl[2]))*62 + strings.Index(base62Chars, string(shortUrl[3]))
@@ -60,9 +75,55 @@ func decodeBase62(shortUrl string) UrlId {
 type URLShortenerApp struct {
 	urlRepo     UrlDB
 	idGenerator UniqueIDGenerator
+	codes       codecChain // The default codecs if empty
 }
 
+func (app *URLShortenerApp) codecs() codecChain {
+	if len(app.codes) == 0 {
+		return defaultCodecs
+	}
+	return app.codes
+}
+
+// shortCode is the code for id in short URLs
+func (app *URLShortenerApp) shortCode(id UrlId) string {
+	return app.codecs().encode(id)
+}
+
+// ErrSettingsConflict is returned when shortening a URL that already has a link with
+// different settings
+var ErrSettingsConflict = errors.New("long URL already has a link with different settings")
+
+// ErrUTMConflict is the ErrSettingsConflict for a link that only differs in its UTM
+// parameters. There is one link per long URL, so a link per campaign needs the long
+// URLs to differ, which putting the parameters in them does.
+var ErrUTMConflict = fmt.Errorf("%w: there is one link per long URL, so to have a link per campaign put its UTM parameters in the long URL", ErrSettingsConflict)
+
+// reservedCodes are top level paths with routes of their own, so that a link with one
+// of them as its short URL couldn't be followed
+var reservedCodes = map[string]bool{"livez": true, "readyz": true}
+
+// generateId returns an id for a new link, skipping those with a reserved short URL
+func (app *URLShortenerApp) generateId() (UrlId, error) {
+	for {
+		id, err := app.idGenerator.GenerateUniqueID()
+		if err != nil || !reservedCodes[app.shortCode(id)] {
+			return id, err
+		}
+	}
+}
+
+// maxStoreAttempts is how many ids a new link is tried with before giving up
+const maxStoreAttempts = 3
+
 func (app *URLShortenerApp) shorten(longUrl string) (string, error) {
+	return app.shortenWithSettings(longUrl, LinkSettings{}, "")
+}
+
+// shortenWithSettings is shorten for a link with settings, protected by password if it
+// isn't empty. There is one link per long URL, so settings that differ from those of
+// an existing link are an error, while asking for none returns the existing link as it is.
+func (app *URLShortenerApp) shortenWithSettings(longUrl string, settings LinkSettings, password string) (string, error) {
 	var id UrlId
 	var err error
 
@@ -73,29 +134,102 @@ func (app *URLShortenerApp) shorten(longUrl string) (string, error) {
 	}
 
 	// If not, generate and save
+	created := false
 	if (id == UrlId{}) { // Generate short url, reassign
-		id = app.idGenerator.GenerateUniqueID()
-		err = app.urlRepo.StoreURLRecord(id, longUrl)
+		link := Link{LongUrl: longUrl, Settings: settings}
+		if password != "" {
+			if link.Settings.PasswordHash, err = hashPassword(password); err != nil {
+				return "", err
+			}
+		}
+		for attempt := 1; ; attempt++ {
+			if id, err = app.generateId(); err != nil {
+				return "", err
+			}
+			link.Id = id
+			err = app.urlRepo.StoreLink(link)
+			// An imported link can have an id the generator hasn't got to yet, so skip it
+			if !errors.Is(err, ErrIdTaken) || attempt == maxStoreAttempts {
+				break
+			}
+		}
+		created = err == nil
+		if errors.Is(err, ErrDuplicateURL) {
+			// Lost a race with a concurrent request for the same URL, use the winner's id
+			id, err = app.urlRepo.GetId(longUrl)
+			if err == nil && (id == UrlId{}) {
+				err = fmt.Errorf("long URL reported as duplicate but not found")
+			}
+		}
 		if err != nil {
 			return "", err
 		}
 	}
 
+	if !created && (!settings.withoutDescription().IsZero() || password != "") {
+		existing, err := app.urlRepo.GetLink(id)
+		if err != nil {
+			return "", err
+		}
+		if !settingsMatch(existing.Settings, settings, password) {
+			retagged := settings
+			retagged.UTM = existing.Settings.UTM
+			if settingsMatch(existing.Settings, retagged, password) {
+				return "", ErrUTMConflict
+			}
+			return "", ErrSettingsConflict
+		}
+	}
+
 	// return
-	return encodeBase62(id), nil
+	return app.shortCode(id), nil
+}
+
+// settingsMatch reports whether an existing link's settings are those requested.
+// Password hashes are salted, so the password is checked against the hash instead.
+// Titles, notes and tags are ignored, they are changed by updating the link.
+func settingsMatch(existing LinkSettings, requested LinkSettings, password string) bool {
+	existing, requested = existing.withoutDescription(), requested.withoutDescription()
+	hash := existing.PasswordHash
+	existing.PasswordHash, requested.PasswordHash = "", ""
+	if !reflect.DeepEqual(existing, requested) {
+		return false
+	}
+	if password == "" || hash == "" {
+		return password == "" && hash == ""
+	}
+	return checkPassword(hash, password)
+}
+
+// lookup returns the link for shortUrl, zeroed out if there isn't one. A code that
+// more than one codec can decode is looked up as each, in the order of the codecs.
+func (app *URLShortenerApp) lookup(shortUrl string) (Link, error) {
+	for _, id := range app.codecs().decode(shortUrl) {
+		link, err := app.urlRepo.GetLink(id)
+		if err != nil || link.Exists() {
+			return link, err
+		}
+	}
+	return Link{}, nil
 }
 
 func (app *URLShortenerApp) redirect(shortUrl string) (string, error) {
-	id := decodeBase62(shortUrl)
-	longUrl, err := app.urlRepo.GetLongURL(id)
-	if err != nil {
-		return "", err
+	for _, id := range app.codecs().decode(shortUrl) {
+		longUrl, err := app.urlRepo.GetLongURL(id)
+		if err != nil || longUrl != "" {
+			return longUrl, err
+		}
 	}
-	return longUrl, nil
+	return "", nil
+}
+
+// recordClick counts a visit to link, returning false if it has no clicks left
+func (app *URLShortenerApp) recordClick(link Link, click Click) (bool, error) {
+	return app.urlRepo.RecordClick(link.Id, click)
 }
 
 type UniqueIDGenerator interface {
-	GenerateUniqueID() UrlId
+	GenerateUniqueID() (UrlId, error)
 }
 
 type UniqueIDGeneratorImpl struct {
@@ -113,7 +247,7 @@ func newUniqueIDGenerator() *UniqueIDGeneratorImpl {
 	return u
 }
 
-func (uidg *UniqueIDGeneratorImpl) GenerateUniqueID() UrlId {
+func (uidg *UniqueIDGeneratorImpl) GenerateUniqueID() (UrlId, error) {
 	uidg.lock.Lock()
 	defer uidg.lock.Unlock()
 
@@ -128,7 +262,14 @@ func (uidg *UniqueIDGeneratorImpl) GenerateUniqueID() UrlId {
 		panic(err)
 	}
 	uidg.seq++
-	return id
+	return id, nil
+}
+
+// Running reports whether the sequence is still being reset every second
+func (uidg *UniqueIDGeneratorImpl) Running() bool {
+	uidg.lock.Lock()
+	defer uidg.lock.Unlock()
+	return time.Now().Unix()-int64(uidg.seconds) <= 2
 }
 
 func (uidg *UniqueIDGeneratorImpl) startSeqReset() {
@@ -159,3 +300,10 @@ func encodeID(buf *UrlId, seconds uint32, seq uint32) error {
 
 	return nil
 }
+
+// decodeID is the inverse of encodeID
+func decodeID(id UrlId) (seconds uint32, seq uint32) {
+	seconds = uint32(id[0])<<24 | uint32(id[1])<<16 | uint32(id[2])<<8 | uint32(id[3])
+	seq = uint32(id[4]&0x7f)<<16 | uint32(id[5])<<8 | uint32(id[6]) // Drop the padding bit
+	return seconds, seq
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatJSONL = "jsonl"
+	formatCSV   = "csv"
+
+	defaultImportBatch = 500
+	maxImportBatch     = 1000 // Keeps a batch's insert well under MySQL's limit on placeholders
+	maxImportErrors    = 1000 // Failed records listed in an API response, the rest are only counted
+	maxRecordLen       = 1 << 20
+)
+
+// bulkRecord is one link in an import or export, as a JSON line or a CSV row. The short
+// URL is optional on import, where it keeps a link's address, e.g. when moving links
+// between instances.
+type bulkRecord struct {
+	ShortUrl  string   `json:"shortUrl,omitempty"`
+	LongUrl   string   `json:"longUrl"`
+	Tags      []string `json:"tags,omitempty"`      // Separated by spaces in CSV
+	ExpiresAt string   `json:"expiresAt,omitempty"` // RFC 3339, when the link stops working
+	Clicks    *uint64  `json:"clicks,omitempty"`    // Only exported, when asked for
+	Line      int      `json:"line,omitempty"`      // Only in the failures of an import
+	Error     string   `json:"error,omitempty"`
+}
+
+var (
+	exportColumns  = []string{"shortUrl", "longUrl", "tags", "expiresAt"}
+	failureColumns = []string{"shortUrl", "longUrl", "tags", "expiresAt", "line", "error"}
+)
+
+// bulkFormat picks the format named, or failing that the one the file name suggests
+func bulkFormat(name string, fileName string) (string, error) {
+	switch strings.ToLower(name) {
+	case formatJSONL, formatCSV:
+		return strings.ToLower(name), nil
+	case "":
+		if strings.HasSuffix(strings.ToLower(fileName), ".csv") {
+			return formatCSV, nil
+		}
+		return formatJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, expected %s or %s", name, formatJSONL, formatCSV)
+	}
+}
+
+// errMalformedHeader is returned for a CSV header that the rows can't be read by
+var errMalformedHeader = errors.New("malformed CSV header")
+
+// malformedRecordError is a record that couldn't be read, which doesn't stop the rest
+type malformedRecordError struct {
+	line int
+	err  error
+}
+
+func (e malformedRecordError) Error() string {
+	return e.err.Error()
+}
+
+// recordReader reads records one at a time, returning io.EOF after the last
+type recordReader interface {
+	read() (bulkRecord, error)
+}
+
+func newRecordReader(format string, r io.Reader) recordReader {
+	if format == formatCSV {
+		cr := csv.NewReader(r)
+		cr.ReuseRecord = true
+		return &csvRecordReader{r: cr}
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRecordLen)
+	return &jsonlRecordReader{scanner: scanner}
+}
+
+type jsonlRecordReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (jr *jsonlRecordReader) read() (bulkRecord, error) {
+	for jr.scanner.Scan() {
+		jr.line++
+		if strings.TrimSpace(jr.scanner.Text()) == "" {
+			continue
+		}
+		var record bulkRecord
+		if err := json.Unmarshal(jr.scanner.Bytes(), &record); err != nil {
+			return bulkRecord{}, malformedRecordError{jr.line, err}
+		}
+		record.Line, record.Error = jr.line, ""
+		return record, nil
+	}
+	if err := jr.scanner.Err(); err != nil {
+		return bulkRecord{}, err
+	}
+	return bulkRecord{}, io.EOF
+}
+
+// csvRecordReader reads rows under a header naming their columns, in any order.
+// Columns written by export or into a file of failures that mean nothing to an
+// import are ignored.
+type csvRecordReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func (cr *csvRecordReader) read() (bulkRecord, error) {
+	if cr.columns == nil {
+		if err := cr.readHeader(); err != nil {
+			return bulkRecord{}, err
+		}
+	}
+
+	row, err := cr.r.Read()
+	line, _ := cr.r.FieldPos(0)
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return bulkRecord{}, malformedRecordError{parseErr.StartLine, err}
+	}
+	if err != nil {
+		return bulkRecord{}, err
+	}
+
+	field := func(name string) string {
+		if i, exists := cr.columns[name]; exists {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	return bulkRecord{
+		ShortUrl:  field("shorturl"),
+		LongUrl:   field("longurl"),
+		Tags:      strings.Fields(field("tags")),
+		ExpiresAt: field("expiresat"),
+		Line:      line,
+	}, nil
+}
+
+func (cr *csvRecordReader) readHeader() error {
+	header, err := cr.r.Read()
+	if errors.Is(err, io.EOF) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", errMalformedHeader, err)
+	}
+	cr.columns = make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case "shorturl", "longurl", "tags", "expiresat":
+			cr.columns[name] = i
+		case "clicks", "line", "error":
+		default:
+			return fmt.Errorf("%w: unknown column %q, expected shortUrl, longUrl, tags or expiresAt", errMalformedHeader, name)
+		}
+	}
+	if _, exists := cr.columns["longurl"]; !exists {
+		return fmt.Errorf("%w: no longUrl column", errMalformedHeader)
+	}
+	return nil
+}
+
+// recordWriter writes records in a format, buffered until flushed
+type recordWriter interface {
+	write(record bulkRecord) error
+	flush() error
+}
+
+// newRecordWriter writes to w, as CSV with the columns given
+func newRecordWriter(format string, w io.Writer, columns []string) recordWriter {
+	bw := bufio.NewWriter(w)
+	if format == formatCSV {
+		return &csvRecordWriter{w: csv.NewWriter(bw), bw: bw, columns: columns}
+	}
+	return &jsonlRecordWriter{enc: json.NewEncoder(bw), bw: bw}
+}
+
+type jsonlRecordWriter struct {
+	enc *json.Encoder
+	bw  *bufio.Writer
+}
+
+func (jw *jsonlRecordWriter) write(record bulkRecord) error {
+	return jw.enc.Encode(record)
+}
+
+func (jw *jsonlRecordWriter) flush() error {
+	return jw.bw.Flush()
+}
+
+type csvRecordWriter struct {
+	w             *csv.Writer
+	bw            *bufio.Writer
+	columns       []string
+	headerWritten bool
+}
+
+func (cw *csvRecordWriter) write(record bulkRecord) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	row := make([]string, len(cw.columns))
+	for i, column := range cw.columns {
+		switch column {
+		case "shortUrl":
+			row[i] = record.ShortUrl
+		case "longUrl":
+			row[i] = record.LongUrl
+		case "tags":
+			row[i] = strings.Join(record.Tags, " ")
+		case "expiresAt":
+			row[i] = record.ExpiresAt
+		case "clicks":
+			if record.Clicks != nil {
+				row[i] = strconv.FormatUint(*record.Clicks, 10)
+			}
+		case "line":
+			row[i] = strconv.Itoa(record.Line)
+		case "error":
+			row[i] = record.Error
+		}
+	}
+	return cw.w.Write(row)
+}
+
+// writeHeader writes the header before the first row, or on flushing if there are none
+func (cw *csvRecordWriter) writeHeader() error {
+	if cw.headerWritten {
+		return nil
+	}
+	cw.headerWritten = true
+	return cw.w.Write(cw.columns)
+}
+
+func (cw *csvRecordWriter) flush() error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+	cw.w.Flush()
+	if err := cw.w.Error(); err != nil {
+		return err
+	}
+	return cw.bw.Flush()
+}
+
+// exportLinks writes every link in id order
+func exportLinks(db UrlDB, codecs codecChain, w recordWriter, withClicks bool) error {
+	err := forEachLink(db, func(link Link) error {
+		record := bulkRecord{ShortUrl: codecs.encode(link.Id), LongUrl: link.LongUrl, Tags: link.Settings.Tags}
+		if link.Settings.NotAfter != nil {
+			record.ExpiresAt = link.Settings.NotAfter.Format(time.RFC3339)
+		}
+		if withClicks {
+			clicks := link.Clicks
+			record.Clicks = &clicks
+		}
+		return w.write(record)
+	})
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+type importStats struct {
+	Read     int `json:"read"`
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"` // Already stored, or earlier in the input
+	Failed   int `json:"failed"`
+}
+
+// bulkImport stores links from records in batches. A long URL that is already stored
+// is skipped, leaving its link as it is. Long URLs are remembered to skip repeats
+// within the input, which takes memory in proportion to its size.
+type bulkImport struct {
+	app        *URLShortenerApp
+	batchSize  int
+	onFailure  func(record bulkRecord) error // Given each record that failed, with its error
+	onProgress func(stats importStats)       // Called after each batch
+
+	stats        importStats
+	seen         map[string]bool
+	claimed      map[UrlId]bool // Short URLs kept by earlier records
+	counterLimit uint64         // Counter ids kept must be below this, zero until known
+	batch        []Link
+	records      []bulkRecord // The record of each link in batch
+}
+
+func newBulkImport(app *URLShortenerApp) *bulkImport {
+	return &bulkImport{
+		app:        app,
+		batchSize:  defaultImportBatch,
+		onFailure:  func(bulkRecord) error { return nil },
+		onProgress: func(importStats) {},
+		seen:       make(map[string]bool),
+		claimed:    make(map[UrlId]bool),
+	}
+}
+
+// run imports every record r reads. An error is returned only for a failure that stops
+// the import, such as losing the database, along with how far it got.
+func (bi *bulkImport) run(r recordReader) (importStats, error) {
+	for {
+		record, err := r.read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var malformed malformedRecordError
+		if errors.As(err, &malformed) {
+			bi.stats.Read++
+			if err = bi.fail(bulkRecord{Line: malformed.line}, err); err != nil {
+				return bi.stats, err
+			}
+			continue
+		}
+		if err != nil {
+			return bi.stats, err
+		}
+
+		bi.stats.Read++
+		if err = bi.add(record); err != nil {
+			return bi.stats, err
+		}
+		if len(bi.batch) >= bi.batchSize {
+			if err = bi.flush(); err != nil {
+				return bi.stats, err
+			}
+		}
+	}
+	err := bi.flush()
+	return bi.stats, err
+}
+
+// add validates record and queues its link for the next batch, unless it's a duplicate
+func (bi *bulkImport) add(record bulkRecord) error {
+	link, err := recordLink(record, bi.app.codecs())
+	if err != nil {
+		return bi.fail(record, err)
+	}
+	if bi.seen[link.LongUrl] {
+		bi.stats.Skipped++
+		return nil
+	}
+	existing, err := bi.app.urlRepo.GetId(link.LongUrl)
+	if err != nil {
+		return err
+	}
+	if existing != (UrlId{}) {
+		bi.seen[link.LongUrl] = true
+		bi.stats.Skipped++
+		return nil
+	}
+
+	if link.Id == (UrlId{}) {
+		if link.Id, err = bi.app.generateId(); err != nil {
+			return err
+		}
+	} else {
+		if isCounterId(link.Id) {
+			allowed, err := bi.counterAllowed(link.Id)
+			if err != nil {
+				return err
+			}
+			if !allowed {
+				return bi.fail(record, fmt.Errorf("shortUrl %s is too far past the codes handed out so far", record.ShortUrl))
+			}
+		}
+		taken := bi.claimed[link.Id]
+		if !taken {
+			longUrl, err := bi.app.urlRepo.GetLongURL(link.Id)
+			if err != nil {
+				return err
+			}
+			taken = longUrl != ""
+		}
+		if taken {
+			return bi.fail(record, fmt.Errorf("shortUrl %s is already taken", record.ShortUrl))
+		}
+		bi.claimed[link.Id] = true
+	}
+
+	bi.seen[link.LongUrl] = true
+	bi.batch = append(bi.batch, link)
+	bi.records = append(bi.records, record)
+	return nil
+}
+
+// flush stores the batch. A batch that collides with links stored since it was checked
+// is stored a link at a time instead, to find those that did.
+func (bi *bulkImport) flush() error {
+	if len(bi.batch) == 0 {
+		return nil
+	}
+	defer func() {
+		bi.batch, bi.records = bi.batch[:0], bi.records[:0]
+		bi.onProgress(bi.stats)
+	}()
+
+	if err := bi.skipImportedIds(); err != nil {
+		return err
+	}
+	err := bi.app.urlRepo.StoreLinks(bi.batch)
+	if err == nil {
+		bi.stats.Imported += len(bi.batch)
+		return nil
+	}
+	if !errors.Is(err, ErrDuplicateURL) && !errors.Is(err, ErrIdTaken) {
+		return err
+	}
+	for i, link := range bi.batch {
+		record := bi.records[i]
+		err = bi.app.urlRepo.StoreLink(link)
+		// A generated id can be taken since, like those shortenWithSettings tries again with
+		for attempt := 1; errors.Is(err, ErrIdTaken) && record.ShortUrl == "" && attempt < maxStoreAttempts; attempt++ {
+			if link.Id, err = bi.app.generateId(); err != nil {
+				return err
+			}
+			err = bi.app.urlRepo.StoreLink(link)
+		}
+		switch {
+		case errors.Is(err, ErrDuplicateURL):
+			bi.stats.Skipped++
+		case errors.Is(err, ErrIdTaken):
+			if err = bi.fail(record, fmt.Errorf("shortUrl %s is already taken", bi.app.shortCode(link.Id))); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			bi.stats.Imported++
+		}
+	}
+	return nil
+}
+
+// maxCounterLead is how far past the next counter id of linkSequence an import may keep
+// ids, so that imports can't use the counter ids up by keeping the last of them
+const maxCounterLead = 1 << 24
+
+// counterAllowed reports whether a counter id is within maxCounterLead of where
+// linkSequence was when the import started
+func (bi *bulkImport) counterAllowed(id UrlId) (bool, error) {
+	if bi.counterLimit == 0 {
+		next, err := bi.app.urlRepo.ReserveIds(linkSequence, 0)
+		if err != nil {
+			return false, err
+		}
+		bi.counterLimit = next + maxCounterLead
+	}
+	return idValue(id) < bi.counterLimit, nil
+}
+
+// skipImportedIds moves linkSequence past the counter ids kept by links in the batch, so
+// that new links aren't given them. Ids the generator made are behind it already.
+func (bi *bulkImport) skipImportedIds() error {
+	var next uint64
+	for _, link := range bi.batch {
+		if isCounterId(link.Id) && idValue(link.Id) >= next {
+			next = idValue(link.Id) + 1
+		}
+	}
+	if next == 0 {
+		return nil
+	}
+	return reserveIdsTo(bi.app.urlRepo, linkSequence, next)
+}
+
+func (bi *bulkImport) fail(record bulkRecord, err error) error {
+	bi.stats.Failed++
+	record.Error = err.Error()
+	return bi.onFailure(record)
+}
+
+// recordLink validates record, returning the link it describes. The id is left zeroed
+// out unless the record keeps a short URL.
+func recordLink(record bulkRecord, codecs codecChain) (Link, error) {
+	link := Link{LongUrl: strings.TrimSpace(record.LongUrl)}
+	if link.LongUrl == "" {
+		return Link{}, errors.New("longUrl is required")
+	}
+	if record.ShortUrl != "" {
+		ids := codecs.decode(record.ShortUrl)
+		if len(ids) == 0 || ids[0] == (UrlId{}) {
+			return Link{}, fmt.Errorf("%q is not a valid short URL", record.ShortUrl)
+		}
+		if reservedCodes[codecs.encode(ids[0])] {
+			return Link{}, fmt.Errorf("%q is reserved", record.ShortUrl)
+		}
+		link.Id = ids[0]
+	}
+	link.Settings.Tags = normalizeTags(record.Tags)
+	if err := validateDescription(link.Settings); err != nil {
+		return Link{}, err
+	}
+	if record.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, record.ExpiresAt)
+		if err != nil {
+			return Link{}, errors.New("expiresAt must be an RFC 3339 time")
+		}
+		link.Settings.NotAfter = windowTime(&expiresAt)
+	}
+	return link, nil
+}
+
+// importResponse reports on an import, listing the first of the records that failed
+type importResponse struct {
+	importStats
+	Errors []bulkRecord `json:"errors"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// requestFormat is the format asked for with the format param, or else the content type
+func requestFormat(c *gin.Context, contentType string) (string, error) {
+	if c.Query("format") == "" && strings.Contains(contentType, "csv") {
+		return formatCSV, nil
+	}
+	return bulkFormat(c.Query("format"), "")
+}
+
+// handleImport stores links from the CSV or JSON lines in the request body, as it's read.
+// Only admins can import, as imported links can keep any short URL that's free.
+func (s *server) handleImport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, err := requestFormat(c, c.ContentType())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		resp := importResponse{Errors: []bulkRecord{}}
+		bi := newBulkImport(s.app)
+		bi.onFailure = func(record bulkRecord) error {
+			if len(resp.Errors) < maxImportErrors {
+				resp.Errors = append(resp.Errors, record)
+			}
+			return nil
+		}
+		resp.importStats, err = bi.run(newRecordReader(format, c.Request.Body))
+		if err != nil {
+			_ = c.Error(err)
+			resp.Error = "import stopped early: " + err.Error()
+			status := http.StatusInternalServerError
+			if errors.Is(err, errMalformedHeader) || errors.Is(err, bufio.ErrTooLong) {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, resp)
+			return
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleExport streams every link, with its click total if asked for with clicks=true.
+// Exports hold every destination, protected or not, so only admins can make them.
+func (s *server) handleExport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		format, err := bulkFormat(c.Query("format"), "")
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		withClicks := c.Query("clicks") == "true"
+		columns := exportColumns
+		if withClicks {
+			columns = append(columns[:len(columns):len(columns)], "clicks")
+		}
+
+		contentType := "application/x-ndjson"
+		if format == formatCSV {
+			contentType = "text/csv; charset=utf-8"
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+		c.Status(http.StatusOK)
+		if err = exportLinks(s.db, s.app.codecs(), newRecordWriter(format, c.Writer, columns), withClicks); err != nil {
+			_ = c.Error(err) // Too late to change the status, the export just ends early
+			c.Abort()
+		}
+	}
+}
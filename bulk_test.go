@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Import_CSV(t *testing.T) {
+	db := &InMemoryUrlDb{}
+	if err := db.StoreURLRecord(UrlId{1, 2, 3, 4, 5}, "https://example.com/stored"); err != nil {
+		t.Fatal(err)
+	}
+	input := "longUrl,tags,expiresAt\n" +
+		"https://example.com/a,Promo spring,2030-01-01T00:00:00Z\n" +
+		"https://example.com/stored,,\n" +
+		"https://example.com/a,,\n" +
+		"https://example.com/b,,next week\n" +
+		"https://example.com/c,bad!tag,\n"
+	errorsFile := filepath.Join(t.TempDir(), "failed.csv")
+
+	c, _, stderr := newTestCLI(db, input)
+	if code := c.run([]string{"import", "-format", "csv", "-errors", errorsFile}); code != exitFailure {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitFailure, code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "imported 1, skipped 2 duplicates, 2 failed") {
+		t.Errorf("Unexpected import summary %q", stderr.String())
+	}
+
+	id, _ := db.GetId("https://example.com/a")
+	link, _ := db.GetLink(id)
+	if strings.Join(link.Settings.Tags, " ") != "promo spring" {
+		t.Errorf("Expected normalized tags, got %v", link.Settings.Tags)
+	}
+	if link.Settings.NotAfter == nil || link.Settings.NotAfter.Year() != 2030 {
+		t.Errorf("Expected the expiry as notAfter, got %v", link.Settings.NotAfter)
+	}
+
+	failed, err := os.ReadFile(errorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(failed)), "\n")
+	if len(lines) != 3 || lines[0] != strings.Join(failureColumns, ",") {
+		t.Fatalf("Expected a header and 2 failed rows, got %q", failed)
+	}
+	if lines[1] != ",https://example.com/b,,next week,5,expiresAt must be an RFC 3339 time" {
+		t.Errorf("Unexpected failed row %q", lines[1])
+	}
+}
+
+func TestCLI_Import_UnknownColumn(t *testing.T) {
+	c, _, _ := newTestCLI(&InMemoryUrlDb{}, "url,owner\nhttps://example.com,alice\n")
+	if code := c.run([]string{"import", "-format", "csv"}); code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+}
+
+func TestCLI_Import_TakenShortUrl(t *testing.T) {
+	db := &InMemoryUrlDb{}
+	shortUrl := encodeBase62(UrlId{1, 2, 3, 4, 5})
+	if err := db.StoreURLRecord(UrlId{1, 2, 3, 4, 5}, "https://example.com/first"); err != nil {
+		t.Fatal(err)
+	}
+
+	c, _, stderr := newTestCLI(db, `{"shortUrl": "`+shortUrl+`", "longUrl": "https://example.com/second"}`+"\n")
+	if code := c.run([]string{"import"}); code != exitFailure {
+		t.Fatalf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr.String(), "already taken") {
+		t.Errorf("Expected the short URL to be reported as taken, got %q", stderr.String())
+	}
+}
+
+func postImport(s *server, contentType string, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/links/import", strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_ImportExport(t *testing.T) {
+	s := newTestServer(t)
+	body := `{"longUrl": "https://example.com/1", "tags": ["a"]}
+{"longUrl": "https://example.com/2", "expiresAt": "2030-06-01T12:00:00Z"}
+{"longUrl": ""}
+`
+	if rec := postImport(s, "application/x-ndjson", body, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 from import without the admin token, got %d", rec.Code)
+	}
+	rec := postImport(s, "application/x-ndjson", body, asAdmin())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from import, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp importResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Imported != 2 || resp.Failed != 1 || len(resp.Errors) != 1 || resp.Errors[0].Line != 3 {
+		t.Errorf("Unexpected import report %+v", resp)
+	}
+
+	if rec = doRequest(s, http.MethodGet, "/api/v1/links/export", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 from export without the admin token, got %d", rec.Code)
+	}
+	rec = doRequest(s, http.MethodGet, "/api/v1/links/export?format=csv&clicks=true", asAdmin())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from export, got %d", rec.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "shortUrl,longUrl,tags,expiresAt,clicks" {
+		t.Fatalf("Unexpected export %q", rec.Body.String())
+	}
+	if !strings.HasSuffix(lines[2], ",https://example.com/2,,2030-06-01T12:00:00Z,0") {
+		t.Errorf("Unexpected exported row %q", lines[2])
+	}
+}
+
+func TestServer_Import_MalformedHeader(t *testing.T) {
+	s := newTestServer(t)
+	if rec := postImport(s, "text/csv", "shortUrl\nEjEI4qOkHp\n", asAdmin()); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a CSV without longUrl, got %d", rec.Code)
+	}
+}
+
+func TestServer_Import_GeneratedIdTaken(t *testing.T) {
+	s := newTestServer(t)
+	s.app.codes = codecChain{compactCodec{}}
+	s.app.idGenerator = newCounterIDGenerator(s.db, defaultIdBlockSize)
+	if err := s.db.StoreLink(Link{Id: counterId(firstCounter), LongUrl: "https://example.com/stored"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := postImport(s, "application/x-ndjson", `{"longUrl": "https://example.com/imported"}`+"\n", asAdmin())
+	var resp importResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Imported != 1 || resp.Failed != 0 {
+		t.Errorf("Expected the link to be stored with another id, got %+v", resp)
+	}
+	if link, _ := s.app.lookup("1001"); link.LongUrl != "https://example.com/imported" {
+		t.Errorf("Expected the next id to be used, got %+v", link)
+	}
+}
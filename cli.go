@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+// usageError marks a failure caused by how a command was invoked
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+var errNotFound = errors.New("not found")
+
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	cfg    config
+	openDB func(dsn string) (UrlDB, func(), error)
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+func cliCommands() []command {
+	return []command{
+		{"serve", "[flags]", "run the HTTP server (default)", (*cli).serve},
+		{"migrate", "up|down|status [flags]", "apply, revert or list schema migrations", (*cli).migrate},
+		{"shorten", "[flags] <url>", "print the short URL for a long URL, creating it if needed", (*cli).shorten},
+		{"resolve", "[flags] <shortUrl>", "print the long URL behind a short URL", (*cli).resolve},
+		{"decode", "<shortUrl>", "print the timestamp and sequence encoded in a short URL", (*cli).decode},
+		{"info", "[flags] <shortUrl>", "print what a short URL encodes and what is stored for it", (*cli).info},
+		{"export", "[flags]", "write every link as JSON lines or CSV", (*cli).export},
+		{"import", "[flags]", "store links from JSON lines or CSV, such as written by export", (*cli).importRecords},
+		{"fsck", "[flags]", "check stored links for bad ids and long URLs", (*cli).fsck},
+		{"migrate-data", "-from <store> -to <store> [flags]", "copy every link from one store to another", (*cli).migrateData},
+	}
+}
+
+func openSQLRepo(dsn string) (UrlDB, func(), error) {
+	db, dbTidy, err := buildSQLRepo("mysql", dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build SQL db: %w", err)
+	}
+	return db, dbTidy, nil
+}
+
+// runCLI runs the subcommand named by args[0] and returns the process exit code
+func runCLI(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	cfg, err := configFromEnv()
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s\n", err)
+		return exitFailure
+	}
+
+	c := &cli{
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		cfg:    cfg,
+		openDB: openSQLRepo,
+	}
+	return c.run(args)
+}
+
+func (c *cli) run(args []string) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		c.usage()
+		return exitOK
+	}
+
+	for _, cmd := range cliCommands() {
+		if cmd.name == name {
+			return c.exitCode(cmd.run(c, args))
+		}
+	}
+
+	_, _ = fmt.Fprintf(c.stderr, "unknown command %q\n\n", name)
+	c.usage()
+	return exitUsage
+}
+
+func (c *cli) usage() {
+	_, _ = fmt.Fprintf(c.stderr, "Usage: urlshortener <command> [arguments]\n\nCommands:\n")
+	for _, cmd := range cliCommands() {
+		_, _ = fmt.Fprintf(c.stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	_, _ = fmt.Fprintf(c.stderr, "\nRun 'urlshortener <command> -h' for a command's flags.\n")
+}
+
+func (c *cli) exitCode(err error) int {
+	var usageErr usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usageErr):
+		_, _ = fmt.Fprintf(c.stderr, "%s\n", err)
+		return exitUsage
+	case errors.Is(err, errNotFound):
+		_, _ = fmt.Fprintf(c.stderr, "%s\n", err)
+		return exitNotFound
+	default:
+		_, _ = fmt.Fprintf(c.stderr, "%s\n", err)
+		return exitFailure
+	}
+}
+
+// flags builds a flag set for the named command, which reports problems to stderr
+func (c *cli) flags(name string) *flag.FlagSet {
+	var args string
+	for _, cmd := range cliCommands() {
+		if cmd.name == name {
+			args = cmd.args
+		}
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(c.stderr, "Usage: urlshortener %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses args into fs, requiring exactly nArgs positional arguments
+func parse(fs *flag.FlagSet, args []string, nArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{err.Error()}
+	}
+	if fs.NArg() != nArgs {
+		fs.Usage()
+		return usageError{fmt.Sprintf("%s: expected %d argument(s), got %d", fs.Name(), nArgs, fs.NArg())}
+	}
+	return nil
+}
+
+// openStore opens the store named by spec, a backend and its DSN such as mysql:<dsn>
+func (c *cli) openStore(spec string) (UrlDB, func(), error) {
+	backend, dsn, found := strings.Cut(spec, ":")
+	switch {
+	case !found:
+		return nil, nil, usageError{fmt.Sprintf("%q should name a backend, as in mysql:<dsn>", spec)}
+	case backend == "mysql":
+		return c.openDB(dsn)
+	default:
+		return nil, nil, usageError{fmt.Sprintf("unknown backend %q, expected mysql", backend)}
+	}
+}
+
+// parseShortUrl returns the ids shortUrl could stand for with the configured codecs
+func (c *cli) parseShortUrl(shortUrl string) ([]UrlId, error) {
+	ids := c.cfg.codecs.decode(shortUrl)
+	if len(ids) == 0 {
+		return nil, usageError{fmt.Sprintf("%q is not a valid short URL", shortUrl)}
+	}
+	return ids, nil
+}
+
+func (c *cli) serve(args []string) error {
+	fs := c.flags("serve")
+	fs.StringVar(&c.cfg.port, "port", c.cfg.port, "port to listen on")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	migrate := fs.Bool("migrate", false, "apply pending schema migrations before starting")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *migrate {
+		err := c.withMigrator(c.cfg.dsn, func(m *migrator) error {
+			return m.up(context.Background(), m.latest(), false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+
+	return runServer(c.cfg, db)
+}
+
+func (c *cli) migrate(args []string) error {
+	fs := c.flags("migrate")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	dryRun := fs.Bool("dry-run", false, "print the SQL that would run instead of running it")
+	to := fs.Int("to", 0, "version to migrate up to (default latest)")
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+	lockTimeout := fs.Duration("lock-timeout", 30*time.Second, "how long to wait for another instance that is migrating")
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		return usageError{"migrate: expected one of up, down or status"}
+	}
+	action := args[0]
+	if err := parse(fs, args[1:], 0); err != nil {
+		return err
+	}
+
+	return c.withMigrator(c.cfg.dsn, func(m *migrator) error {
+		m.lockTimeout = *lockTimeout
+		ctx := context.Background()
+		switch action {
+		case "up":
+			target := *to
+			if target == 0 {
+				target = m.latest()
+			}
+			return m.up(ctx, target, *dryRun)
+		case "down":
+			if *steps < 1 {
+				return usageError{"migrate: -steps must be at least 1"}
+			}
+			return m.down(ctx, *steps, *dryRun)
+		case "status":
+			return m.status(ctx)
+		default:
+			return usageError{fmt.Sprintf("migrate: unknown action %q", action)}
+		}
+	})
+}
+
+func (c *cli) withMigrator(dsn string, fn func(m *migrator) error) error {
+	db, err := openSQLDB("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open SQL db: %w", err)
+	}
+	defer db.Close()
+
+	m, err := newMySQLMigrator(db, c.stdout)
+	if err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+func (c *cli) shorten(args []string) error {
+	fs := c.flags("shorten")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+
+	app := &URLShortenerApp{
+		urlRepo:     db,
+		idGenerator: newIDGenerator(c.cfg, db),
+		codes:       c.cfg.codecs,
+	}
+	shortUrl, err := app.shorten(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintln(c.stdout, shortUrl)
+	return nil
+}
+
+func (c *cli) resolve(args []string) error {
+	fs := c.flags("resolve")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	ids, err := c.parseShortUrl(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+
+	longUrl := ""
+	for _, id := range ids {
+		if longUrl, err = db.GetLongURL(id); err != nil {
+			return err
+		}
+		if longUrl != "" {
+			break
+		}
+	}
+	if longUrl == "" {
+		return fmt.Errorf("%s: %w", fs.Arg(0), errNotFound)
+	}
+
+	_, _ = fmt.Fprintln(c.stdout, longUrl)
+	return nil
+}
+
+func (c *cli) decode(args []string) error {
+	fs := c.flags("decode")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	ids, err := c.parseShortUrl(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if isCounterId(ids[0]) {
+		_, _ = fmt.Fprintf(c.stdout, "counter:   %d\n", idValue(ids[0]))
+		return nil
+	}
+	seconds, seq := decodeID(ids[0])
+	_, _ = fmt.Fprintf(c.stdout, "timestamp: %s (%d)\n", time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339), seconds)
+	_, _ = fmt.Fprintf(c.stdout, "sequence:  %d\n", seq)
+	return nil
+}
+
+// info is the API's link info for support, with the metadata always shown since having
+// the DSN is as good as being an admin
+func (c *cli) info(args []string) error {
+	fs := c.flags("info")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	ids, err := c.parseShortUrl(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+	id, link := ids[0], Link{}
+	for _, candidate := range ids {
+		if link, err = db.GetLink(candidate); err != nil {
+			return err
+		}
+		if link.Exists() {
+			id = candidate
+			break
+		}
+	}
+
+	info := newIdInfo(id, c.cfg.codecs.encode(id))
+	_, _ = fmt.Fprintf(c.stdout, "format:    %d\n", info.Format)
+	if info.CreatedAt != nil {
+		_, _ = fmt.Fprintf(c.stdout, "timestamp: %s (%d)\n", info.CreatedAt.Format(time.RFC3339), info.Timestamp)
+	}
+	_, _ = fmt.Fprintf(c.stdout, "sequence:  %d\n", info.Sequence)
+	if !link.Exists() {
+		_, _ = fmt.Fprintf(c.stdout, "exists:    no\n")
+		return fmt.Errorf("%s: %w", fs.Arg(0), errNotFound)
+	}
+	metadata := newLinkMetadata(link, time.Now())
+	_, _ = fmt.Fprintf(c.stdout, "exists:    yes\n")
+	_, _ = fmt.Fprintf(c.stdout, "state:     %s\n", metadata.State)
+	if metadata.NotBefore != nil {
+		_, _ = fmt.Fprintf(c.stdout, "notBefore: %s\n", metadata.NotBefore.Format(time.RFC3339))
+	}
+	if metadata.ExpiresAt != nil {
+		_, _ = fmt.Fprintf(c.stdout, "expires:   %s\n", metadata.ExpiresAt.Format(time.RFC3339))
+	}
+	if metadata.MaxClicks > 0 {
+		_, _ = fmt.Fprintf(c.stdout, "clicks:    %d of %d\n", metadata.Clicks, metadata.MaxClicks)
+	} else {
+		_, _ = fmt.Fprintf(c.stdout, "clicks:    %d\n", metadata.Clicks)
+	}
+	_, _ = fmt.Fprintf(c.stdout, "protected: %t\n", metadata.Protected)
+	return nil
+}
+
+func (c *cli) export(args []string) error {
+	fs := c.flags("export")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	output := fs.String("o", "", "file to write to (default stdout)")
+	formatName := fs.String("format", "", "jsonl or csv (default csv for a .csv file, otherwise jsonl)")
+	withClicks := fs.Bool("clicks", false, "include each link's click total")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	format, err := bulkFormat(*formatName, *output)
+	if err != nil {
+		return usageError{"export: " + err.Error()}
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+
+	w := c.stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	columns := exportColumns
+	if *withClicks {
+		columns = append(columns[:len(columns):len(columns)], "clicks")
+	}
+	return exportLinks(db, c.cfg.codecs, newRecordWriter(format, w, columns), *withClicks)
+}
+
+func (c *cli) importRecords(args []string) error {
+	fs := c.flags("import")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	input := fs.String("i", "", "file to read from (default stdin)")
+	formatName := fs.String("format", "", "jsonl or csv (default csv for a .csv file, otherwise jsonl)")
+	errorsFile := fs.String("errors", "", "file to write the records that failed to, with why, in the same format")
+	batchSize := fs.Int("batch", defaultImportBatch, "links to store per insert")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	format, err := bulkFormat(*formatName, *input)
+	if err != nil {
+		return usageError{"import: " + err.Error()}
+	}
+	if *batchSize < 1 || *batchSize > maxImportBatch {
+		return usageError{fmt.Sprintf("import: -batch must be between 1 and %d", maxImportBatch)}
+	}
+
+	r := c.stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var failures recordWriter
+	if *errorsFile != "" {
+		f, err := os.Create(*errorsFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		failures = newRecordWriter(format, f, failureColumns)
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+
+	bi := newBulkImport(&URLShortenerApp{
+		urlRepo:     db,
+		idGenerator: newIDGenerator(c.cfg, db),
+		codes:       c.cfg.codecs,
+	})
+	bi.batchSize = *batchSize
+	bi.onFailure = func(record bulkRecord) error {
+		_, _ = fmt.Fprintf(c.stderr, "line %d: %s\n", record.Line, record.Error)
+		if failures == nil {
+			return nil
+		}
+		return failures.write(record)
+	}
+	lastReport := time.Now()
+	bi.onProgress = func(stats importStats) {
+		if time.Since(lastReport) >= time.Second {
+			lastReport = time.Now()
+			_, _ = fmt.Fprintf(c.stderr, "read %d: imported %d, skipped %d duplicates, %d failed\n",
+				stats.Read, stats.Imported, stats.Skipped, stats.Failed)
+		}
+	}
+
+	stats, err := bi.run(newRecordReader(format, r))
+	if failures != nil {
+		if flushErr := failures.flush(); err == nil {
+			err = flushErr
+		}
+	}
+	_, _ = fmt.Fprintf(c.stderr, "imported %d, skipped %d duplicates, %d failed\n", stats.Imported, stats.Skipped, stats.Failed)
+	if err != nil {
+		return err
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d record(s) failed to import", stats.Failed)
+	}
+	return nil
+}
+
+func (c *cli) migrateData(args []string) error {
+	fs := c.flags("migrate-data")
+	from := fs.String("from", "", "store to copy from, as mysql:<dsn>")
+	to := fs.String("to", "", "store to copy to, as mysql:<dsn>")
+	checkpoint := fs.String("checkpoint", "", "file recording progress, to resume from if the copy stops")
+	workers := fs.Int("workers", 4, "batches to write at once")
+	batchSize := fs.Int("batch", defaultImportBatch, "links to read and write at a time")
+	verify := fs.Bool("verify", true, "compare the stores once copied")
+	samples := fs.Int("sample", 100, "links to compare record by record when verifying")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	switch {
+	case *from == "" || *to == "":
+		fs.Usage()
+		return usageError{"migrate-data: -from and -to are required"}
+	case *from == *to:
+		return usageError{"migrate-data: -from and -to are the same store"}
+	case *workers < 1:
+		return usageError{"migrate-data: -workers must be at least 1"}
+	case *batchSize < 1 || *batchSize > maxImportBatch:
+		return usageError{fmt.Sprintf("migrate-data: -batch must be between 1 and %d", maxImportBatch)}
+	}
+
+	source, sourceTidy, err := c.openStore(*from)
+	if err != nil {
+		return err
+	}
+	defer sourceTidy()
+	target, targetTidy, err := c.openStore(*to)
+	if err != nil {
+		return err
+	}
+	defer targetTidy()
+
+	dm := newDataMigration(source, target)
+	dm.codecs, dm.workers, dm.batchSize, dm.checkpoint = c.cfg.codecs, *workers, *batchSize, *checkpoint
+	lastReport := time.Now()
+	dm.onProgress = func(copied int, last UrlId) {
+		if time.Since(lastReport) >= time.Second {
+			lastReport = time.Now()
+			_, _ = fmt.Fprintf(c.stderr, "copied %d links, up to %s\n", copied, c.cfg.codecs.encode(last))
+		}
+	}
+	copied, err := dm.copy()
+	_, _ = fmt.Fprintf(c.stderr, "copied %d links\n", copied)
+	if err != nil {
+		return err
+	}
+	if !*verify {
+		return nil
+	}
+
+	report, err := dm.verify(*samples)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.stderr, "verified: %d links in the source, %d in the target, %d of %d sampled links match\n",
+		report.sourceLinks, report.targetLinks, report.sampled-len(report.mismatched), report.sampled)
+	for _, mismatch := range report.mismatched {
+		_, _ = fmt.Fprintf(c.stderr, "  %s\n", mismatch)
+	}
+	if !report.ok() {
+		return errors.New("migrate-data: the target doesn't match the source")
+	}
+	return nil
+}
+
+func (c *cli) fsck(args []string) error {
+	fs := c.flags("fsck")
+	fs.StringVar(&c.cfg.dsn, "dsn", c.cfg.dsn, "MySQL data source name")
+	repair := fs.Bool("repair", false, "move links with the padding bit set to the id their short URL leads to")
+	maxSkew := fs.Duration("max-skew", time.Minute, "how far in the future an id's timestamp may be")
+	if err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	db, dbTidy, err := c.openDB(c.cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer dbTidy()
+
+	f := newFsck(db)
+	f.codecs, f.repair, f.maxSkew = c.cfg.codecs, *repair, *maxSkew
+	f.onIssue = func(issue fsckIssue) {
+		_, _ = fmt.Fprintln(c.stdout, issue)
+	}
+	report, err := f.run()
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(c.stderr, "checked %d links: %d problem(s), %d repaired\n", report.checked, report.issues, report.repaired)
+	if report.issues > report.repaired {
+		return fmt.Errorf("fsck: %d problem(s) left", report.issues-report.repaired)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func newTestCLI(db *InMemoryUrlDb, stdin string) (*cli, *bytes.Buffer, *bytes.Buffer) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	c := &cli{
+		stdin:  strings.NewReader(stdin),
+		stdout: stdout,
+		stderr: stderr,
+		cfg:    defaultConfig(),
+		openDB: func(dsn string) (UrlDB, func(), error) {
+			return db, func() {}, nil
+		},
+	}
+	return c, stdout, stderr
+}
+
+func TestCLI_UnknownCommand(t *testing.T) {
+	c, _, _ := newTestCLI(&InMemoryUrlDb{}, "")
+	if code := c.run([]string{"frobnicate"}); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCLI_ShortenAndResolve(t *testing.T) {
+	db := &InMemoryUrlDb{}
+
+	c, stdout, _ := newTestCLI(db, "")
+	if code := c.run([]string{"shorten", "www.google.com"}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+	}
+	shortUrl := strings.TrimSpace(stdout.String())
+
+	c, stdout, _ = newTestCLI(db, "")
+	if code := c.run([]string{"resolve", shortUrl}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+	}
+	if strings.TrimSpace(stdout.String()) != "www.google.com" {
+		t.Error("resolve should print the long URL")
+	}
+}
+
+func TestCLI_Resolve_NotFound(t *testing.T) {
+	c, _, _ := newTestCLI(&InMemoryUrlDb{}, "")
+	if code := c.run([]string{"resolve", "EjEI4qOkHp"}); code != exitNotFound {
+		t.Errorf("Expected exit code %d, got %d", exitNotFound, code)
+	}
+}
+
+func TestCLI_Resolve_MissingArgument(t *testing.T) {
+	c, _, _ := newTestCLI(&InMemoryUrlDb{}, "")
+	if code := c.run([]string{"resolve"}); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCLI_Decode(t *testing.T) {
+	id := UrlId{}
+	if err := encodeID(&id, 1714085905, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	c, stdout, _ := newTestCLI(&InMemoryUrlDb{}, "")
+	if code := c.run([]string{"decode", encodeBase62(id)}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+	}
+	expected := "timestamp: 2024-04-25T22:58:25Z (1714085905)\nsequence:  42\n"
+	if stdout.String() != expected {
+		t.Errorf("Unexpected decode output %q", stdout.String())
+	}
+}
+
+func TestCLI_Decode_Invalid(t *testing.T) {
+	c, _, _ := newTestCLI(&InMemoryUrlDb{}, "")
+	if code := c.run([]string{"decode", "short"}); code != exitUsage {
+		t.Errorf("Expected exit code %d, got %d", exitUsage, code)
+	}
+}
+
+func TestCLI_ExportImport(t *testing.T) {
+	source := &InMemoryUrlDb{records: []InMemoryUrlDbRecord{{UrlId{1, 2, 3, 4, 5}, "long1"}, {UrlId{5, 4, 3, 2, 1}, "long2"}}}
+
+	c, stdout, _ := newTestCLI(source, "")
+	if code := c.run([]string{"export"}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+	}
+
+	target := &InMemoryUrlDb{records: []InMemoryUrlDbRecord{{UrlId{1, 2, 3, 4, 5}, "long1"}}}
+	c, _, stderr := newTestCLI(target, stdout.String())
+	if code := c.run([]string{"import"}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "imported 1, skipped 1 duplicates, 0 failed") {
+		t.Errorf("Unexpected import summary %q", stderr.String())
+	}
+	long, _ := target.GetLongURL(UrlId{5, 4, 3, 2, 1})
+	if long != "long2" {
+		t.Error("import should keep the exported short URL")
+	}
+}
+
+func TestCLI_Import_ReportsFailures(t *testing.T) {
+	c, _, stderr := newTestCLI(&InMemoryUrlDb{}, "{\"shortUrl\": \"bad\", \"longUrl\": \"long\"}\nnot json\n")
+	if code := c.run([]string{"import"}); code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stderr.String(), "line 2:") {
+		t.Error("import should report the line of each failure")
+	}
+}
@@ -0,0 +1,397 @@
+// Package client is a Go client for the URL shortener's HTTP API.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 100 * time.Millisecond
+)
+
+// APIError is returned when the server answers with a non-2xx status
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("urlshortener: %d %s", e.StatusCode, e.Message)
+}
+
+// ErrNotFound is returned when the short URL is not known to the server
+var ErrNotFound = errors.New("urlshortener: short URL not found")
+
+// Client talks to a single URL shortener deployment. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	apiKey     string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithAPIKey sends key as a bearer token with every request
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithTimeout bounds each individual attempt, not the call as a whole
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// WithRetries sets how many times a failed attempt is retried, waiting backoff before
+// the first retry and doubling it each time after
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// WithHTTPClient replaces the underlying http.Client. Apply it before WithTimeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme and host are required", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Shorten returns the short URL for longURL, creating it if needed. Retries reuse the
+// same Idempotency-Key so that they are never processed twice.
+func (c *Client) Shorten(ctx context.Context, longURL string) (string, error) {
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ShortUrl string `json:"shortUrl"`
+	}
+	query := url.Values{"longUrl": {longURL}}
+	header := http.Header{"Idempotency-Key": {key}}
+	if err := c.do(ctx, http.MethodPost, "api/v1/shorten", query, header, &resp); err != nil {
+		return "", err
+	}
+	return resp.ShortUrl, nil
+}
+
+// ShortenBatch shortens each of longURLs in turn. The returned slice lines up with
+// longURLs and holds the results gathered before any error.
+func (c *Client) ShortenBatch(ctx context.Context, longURLs []string) ([]string, error) {
+	shortURLs := make([]string, 0, len(longURLs))
+	for _, longURL := range longURLs {
+		shortURL, err := c.Shorten(ctx, longURL)
+		if err != nil {
+			return shortURLs, fmt.Errorf("shortening %q: %w", longURL, err)
+		}
+		shortURLs = append(shortURLs, shortURL)
+	}
+	return shortURLs, nil
+}
+
+// Resolve returns the long URL behind shortURL, or ErrNotFound
+func (c *Client) Resolve(ctx context.Context, shortURL string) (string, error) {
+	var resp struct {
+		LongUrl string `json:"longUrl"`
+	}
+	err := c.do(ctx, http.MethodGet, "api/v1/redirect", url.Values{"shortUrl": {shortURL}}, nil, &resp)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && apiErr.Message == "shortUrl not known" {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.LongUrl, nil
+}
+
+// Stats is how many times a link has been followed, broken down by the variant visitors
+// were sent to and the country they were in, where known. Variants are left out for
+// links that keep their destinations from visitors, unless asked with the admin token.
+type Stats struct {
+	Clicks    uint64            `json:"clicks"`
+	Variants  map[string]uint64 `json:"variants"`
+	Countries map[string]uint64 `json:"countries"`
+}
+
+// Stats returns the clicks on shortURL, or ErrNotFound
+func (c *Client) Stats(ctx context.Context, shortURL string) (Stats, error) {
+	var stats Stats
+	err := c.do(ctx, http.MethodGet, "api/v1/links/"+shortURL+"/stats", nil, nil, &stats)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return Stats{}, ErrNotFound
+	}
+	return stats, err
+}
+
+// Delete deletes shortURL's link, with its clicks and history, or returns ErrNotFound.
+// The server only allows this with its admin token as the API key.
+func (c *Client) Delete(ctx context.Context, shortURL string) error {
+	err := c.do(ctx, http.MethodDelete, "api/v1/links/"+shortURL, nil, nil, nil)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// LinkQuery picks which links ListLinks returns. Zero fields don't filter.
+type LinkQuery struct {
+	Tag           string
+	Host          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Limit         int    // Links per page, up to the server's maximum
+	Cursor        string // The NextCursor of the previous page, empty for the first
+}
+
+// LinkSummary is a link as it's listed. LongUrl is empty for links whose destination is
+// withheld, such as protected ones.
+type LinkSummary struct {
+	ShortUrl  string    `json:"shortUrl"`
+	LongUrl   string    `json:"longUrl"`
+	Title     string    `json:"title"`
+	Notes     string    `json:"notes"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"createdAt"` // Zero for counter ids, which don't record it
+	Clicks    uint64    `json:"clicks"`
+	Protected bool      `json:"protected"`
+}
+
+// LinkPage is one page of links, oldest first. NextCursor is empty on the last page.
+type LinkPage struct {
+	Links      []LinkSummary `json:"links"`
+	NextCursor string        `json:"nextCursor"`
+}
+
+// ListLinks returns a page of the links query picks
+func (c *Client) ListLinks(ctx context.Context, query LinkQuery) (LinkPage, error) {
+	values := url.Values{}
+	for name, value := range map[string]string{"tag": query.Tag, "host": query.Host, "cursor": query.Cursor} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	for name, t := range map[string]time.Time{"createdAfter": query.CreatedAfter, "createdBefore": query.CreatedBefore} {
+		if !t.IsZero() {
+			values.Set(name, t.Format(time.RFC3339))
+		}
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	var page LinkPage
+	err := c.do(ctx, http.MethodGet, "api/v1/links", values, nil, &page)
+	return page, err
+}
+
+// Version is a link as it was after one change, with the fields that change made
+type Version struct {
+	Version   int                    `json:"version"`
+	Actor     string                 `json:"actor"`
+	ChangedAt time.Time              `json:"changedAt"` // Zero when not recorded
+	LongUrl   string                 `json:"longUrl"`
+	Settings  json.RawMessage        `json:"settings"`
+	Protected bool                   `json:"protected"`
+	Diff      map[string]FieldChange `json:"diff"`
+}
+
+// FieldChange is a field's value before and after a change, null where it wasn't set
+type FieldChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// History returns the versions of shortURL newest first, or ErrNotFound. The server only
+// allows this with its admin token as the API key.
+func (c *Client) History(ctx context.Context, shortURL string) ([]Version, error) {
+	var resp struct {
+		Versions []Version `json:"versions"`
+	}
+	err := c.do(ctx, http.MethodGet, "api/v1/links/"+shortURL+"/history", nil, nil, &resp)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	return resp.Versions, err
+}
+
+// Formats of IDInfo, telling ids made from the time from those counted from a sequence
+const (
+	IDFormatTimestamp = 1
+	IDFormatCounter   = 2
+)
+
+// IDInfo is what a short URL tells about its link without it being looked up. CreatedAt
+// and Timestamp are only set for timestamp ids.
+type IDInfo struct {
+	ShortUrl  string    `json:"shortUrl"`
+	Format    int       `json:"format"`
+	CreatedAt time.Time `json:"createdAt"`
+	Timestamp uint32    `json:"timestamp"`
+	Sequence  uint64    `json:"sequence"`
+}
+
+// Metadata is when and how much a link can be used
+type Metadata struct {
+	State     string     `json:"state"` // active, pending, expired or exhausted
+	NotBefore *time.Time `json:"notBefore"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	MaxClicks uint64     `json:"maxClicks"`
+	Clicks    uint64     `json:"clicks"`
+	Protected bool       `json:"protected"`
+}
+
+// Info is what the server knows about a short URL. Metadata is only there for a link
+// that exists, asked about with the admin token as the API key.
+type Info struct {
+	ID       IDInfo    `json:"id"`
+	Exists   bool      `json:"exists"`
+	Metadata *Metadata `json:"metadata"`
+}
+
+// Info decodes shortURL and reports on its link. A short URL the server can't decode is
+// an *APIError with status 400, while one it can decode without a link isn't an error.
+func (c *Client) Info(ctx context.Context, shortURL string) (Info, error) {
+	var info Info
+	err := c.do(ctx, http.MethodGet, "api/v1/links/"+shortURL+"/info", nil, nil, &info)
+	return info, err
+}
+
+// Health returns nil if the server reports itself ready to serve traffic
+func (c *Client) Health(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "readyz", nil, nil, nil)
+}
+
+// Live returns nil if the server process is up, regardless of its dependencies
+func (c *Client) Live(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "livez", nil, nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, header http.Header, out interface{}) error {
+	u := c.baseURL.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, u.String(), header, out)
+		if err == nil || attempt == c.retries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method string, u string, header http.Header, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newAPIError(res.StatusCode, body)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("urlshortener: malformed response: %w", err)
+	}
+	return nil
+}
+
+func newAPIError(status int, body []byte) *APIError {
+	var errBody struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errBody); err != nil || errBody.Error == "" {
+		errBody.Error = http.StatusText(status)
+	}
+	return &APIError{StatusCode: status, Message: errBody.Error}
+}
+
+// retryable reports whether err may succeed if the request is sent again
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		// 409 means an earlier attempt with the same Idempotency-Key is still running
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == http.StatusConflict
+	}
+	return true // Transport errors, including per-attempt timeouts
+}
+
+func newIdempotencyKey() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/mattyoungberg/urlshortener/client"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.Handler, opts ...client.Option) *client.Client {
+	t.Helper()
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	c, err := client.New(ts.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClient_ShortenAndResolve(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+	ctx := context.Background()
+
+	shortUrl, err := c.Shorten(ctx, "www.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	longUrl, err := c.Resolve(ctx, shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if longUrl != "www.google.com" {
+		t.Error("Resolve should return the URL that was shortened")
+	}
+}
+
+func TestClient_ShortenBatch(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	shortUrls, err := c.ShortenBatch(context.Background(), []string{"www.google.com", "www.bing.com", "www.google.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shortUrls) != 3 {
+		t.Fatalf("Expected 3 short URLs, got %d", len(shortUrls))
+	}
+	if shortUrls[0] != shortUrls[2] || shortUrls[0] == shortUrls[1] {
+		t.Error("Batch results should line up with the long URLs given")
+	}
+}
+
+func TestClient_Resolve_NotFound(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	_, err := c.Resolve(context.Background(), encodeBase62(UrlId{1, 2, 3, 4, 5}))
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_APIError(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	_, err := c.Shorten(context.Background(), "")
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "param `longUrl` is required" {
+		t.Error("APIError should carry the server's status and error message")
+	}
+}
+
+func TestClient_Health(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	if err := c.Health(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_RetriesReuseIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	var lock sync.Mutex
+	var keys []string
+	flaky := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		keys = append(keys, r.Header.Get(idempotencyHeader))
+		attempt := len(keys)
+		lock.Unlock()
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.ServeHTTP(w, r)
+	})
+	c := newTestClient(t, flaky, client.WithRetries(2, time.Millisecond))
+
+	if _, err := c.Shorten(context.Background(), "www.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(keys))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Error("Retries should send the same Idempotency-Key")
+	}
+}
+
+func TestClient_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	c := newTestClient(t, handler, client.WithRetries(3, time.Millisecond))
+
+	if err := c.Health(context.Background()); err == nil {
+		t.Error("Expected an error")
+	}
+	if attempts != 1 {
+		t.Errorf("Client errors should not be retried, got %d attempts", attempts)
+	}
+}
+
+func TestClient_Live(t *testing.T) {
+	c := newTestClient(t, newTestServer(t))
+
+	if err := c.Live(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClient_Stats(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	shortUrl, err := c.Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	stats, err := c.Stats(ctx, shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Clicks != 1 {
+		t.Errorf("Expected 1 click, got %d", stats.Clicks)
+	}
+
+	if _, err = c.Stats(ctx, encodeBase62(UrlId{1, 2, 3, 4, 5})); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_Delete(t *testing.T) {
+	s := newTestServer(t)
+	s.adminToken = []byte("secret")
+	c := newTestClient(t, s, client.WithAPIKey("secret"))
+	ctx := context.Background()
+
+	shortUrl, err := c.Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiErr *client.APIError
+	if err = newTestClient(t, s).Delete(ctx, shortUrl); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the admin token, got %v", err)
+	}
+	if err = c.Delete(ctx, shortUrl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Resolve(ctx, shortUrl); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected the deleted link to be gone, got %v", err)
+	}
+	if err = c.Delete(ctx, shortUrl); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting it again, got %v", err)
+	}
+}
+
+func TestClient_History(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s, client.WithAPIKey(testAdminToken))
+	ctx := context.Background()
+
+	shortUrl, err := c.Shorten(ctx, "https://example.com/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchLink(s, shortUrl, `{"longUrl": "https://example.com/new"}`, "alice")
+	versions, err := c.History(ctx, shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 2 || versions[0].Actor != "alice" || versions[0].LongUrl != "https://example.com/new" {
+		t.Errorf("Unexpected history %+v", versions)
+	}
+	if string(versions[0].Diff["longUrl"].From) != `"https://example.com/old"` {
+		t.Errorf("Unexpected diff %+v", versions[0].Diff)
+	}
+
+	if _, err = c.History(ctx, encodeBase62(UrlId{1, 2, 3, 4, 5})); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_ListLinks(t *testing.T) {
+	s := newTestServer(t)
+	c := newTestClient(t, s)
+	ctx := context.Background()
+
+	var shortUrls []string
+	for _, longUrl := range []string{"https://example.com/a", "https://example.com/b", "https://example.org/c"} {
+		shortUrl, err := c.Shorten(ctx, longUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		shortUrls = append(shortUrls, shortUrl)
+	}
+
+	var listed []string
+	query := client.LinkQuery{Host: "example.com", Limit: 1}
+	for {
+		page, err := c.ListLinks(ctx, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range page.Links {
+			listed = append(listed, link.ShortUrl)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if !reflect.DeepEqual(listed, shortUrls[:2]) {
+		t.Errorf("Expected the links on example.com a page at a time, got %v", listed)
+	}
+}
+
+func TestClient_Info(t *testing.T) {
+	s := newTestServer(t)
+	s.adminToken = []byte("secret")
+	ctx := context.Background()
+
+	shortUrl, err := newTestClient(t, s).Shorten(ctx, "https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := newTestClient(t, s, client.WithAPIKey("secret")).Info(ctx, shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Exists || info.ID.ShortUrl != shortUrl || info.ID.Format != client.IDFormatTimestamp || time.Since(info.ID.CreatedAt) > time.Minute {
+		t.Errorf("Unexpected info %+v", info)
+	}
+	if info.Metadata == nil || info.Metadata.State != "active" {
+		t.Errorf("Expected the metadata with the admin token, got %+v", info.Metadata)
+	}
+
+	info, err = newTestClient(t, s).Info(ctx, encodeBase62(UrlId{1, 2, 3, 4, 5}))
+	if err != nil || info.Exists || info.Metadata != nil {
+		t.Errorf("Expected an unknown link without metadata, got %+v, %v", info, err)
+	}
+}
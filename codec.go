@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Codec turns ids into the codes that short URLs are made of, and back. Every codec
+// drops the padding bit, leaving 55 bits to encode.
+type Codec interface {
+	Name() string
+	Encode(id UrlId) string
+	Decode(code string) (UrlId, bool) // False for a code this codec doesn't produce
+}
+
+// codecs by the name they're configured with
+var codecs = map[string]Codec{
+	"base62":      base62Codec{},
+	"crockford32": crockford32Codec{},
+	"base58":      base58Codec{},
+	"compact":     compactCodec{},
+}
+
+func codecByName(name string) (Codec, error) {
+	codec, exists := codecs[strings.ToLower(strings.TrimSpace(name))]
+	if !exists {
+		return nil, fmt.Errorf("unknown codec %q, expected base62, crockford32, base58 or compact", name)
+	}
+	return codec, nil
+}
+
+// base62Codec is the original scheme, 5 words of 11 bits as 2 characters each
+type base62Codec struct{}
+
+func (base62Codec) Name() string {
+	return "base62"
+}
+
+func (base62Codec) Encode(id UrlId) string {
+	return encodeBase62(id)
+}
+
+// Decode only accepts the code an id encodes to. Two characters can count higher than
+// 11 bits, and those codes would otherwise be aliases for another.
+func (base62Codec) Decode(code string) (UrlId, bool) {
+	if !isShortUrl(code) {
+		return UrlId{}, false
+	}
+	id := decodeBase62(code)
+	return id, encodeBase62(id) == code
+}
+
+// idValue packs the timestamp and sequence of id into an integer, for codecs that
+// encode it as a number
+func idValue(id UrlId) uint64 {
+	seconds, seq := decodeID(id)
+	return uint64(seconds)<<23 | uint64(seq)
+}
+
+// idFromValue is the inverse of idValue, for values below 2^55
+func idFromValue(value uint64) UrlId {
+	id := UrlId{}
+	_ = encodeID(&id, uint32(value>>23), uint32(value&(1<<23-1)))
+	return id
+}
+
+const crockford32Chars = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+const crockford32Len = 11
+
+// crockford32Codec is Crockford's base32, which leaves out I, L, O and U so that codes
+// can be read out and typed back in. Decoding ignores case and hyphens, and takes I and L
+// as 1 and O as 0, as people reading a code might.
+type crockford32Codec struct{}
+
+func (crockford32Codec) Name() string {
+	return "crockford32"
+}
+
+func (crockford32Codec) Encode(id UrlId) string {
+	value := idValue(id)
+	code := make([]byte, crockford32Len)
+	for i := crockford32Len - 1; i >= 0; i-- {
+		code[i] = crockford32Chars[value&0x1f]
+		value >>= 5
+	}
+	return string(code)
+}
+
+var crockford32Aliases = strings.NewReplacer("-", "", "I", "1", "L", "1", "O", "0")
+
+func (crockford32Codec) Decode(code string) (UrlId, bool) {
+	code = crockford32Aliases.Replace(strings.ToUpper(code))
+	if len(code) != crockford32Len {
+		return UrlId{}, false
+	}
+	var value uint64
+	for i := 0; i < len(code); i++ {
+		digit := strings.IndexByte(crockford32Chars, code[i])
+		if digit == -1 {
+			return UrlId{}, false
+		}
+		value = value<<5 | uint64(digit)
+	}
+	return idFromValue(value), true // 11 characters are exactly 55 bits
+}
+
+const base58Chars = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+const base58Len = 10
+
+// base58Codec leaves out 0, O, I and l, which look alike in many fonts, with the
+// alphabet used by Bitcoin
+type base58Codec struct{}
+
+func (base58Codec) Name() string {
+	return "base58"
+}
+
+func (base58Codec) Encode(id UrlId) string {
+	value := idValue(id)
+	code := make([]byte, base58Len)
+	for i := base58Len - 1; i >= 0; i-- {
+		code[i] = base58Chars[value%58]
+		value /= 58
+	}
+	return string(code)
+}
+
+func (base58Codec) Decode(code string) (UrlId, bool) {
+	if len(code) != base58Len {
+		return UrlId{}, false
+	}
+	var value uint64 // 58^10 fits, so this can't overflow
+	for i := 0; i < len(code); i++ {
+		digit := strings.IndexByte(base58Chars, code[i])
+		if digit == -1 {
+			return UrlId{}, false
+		}
+		value = value*58 + uint64(digit)
+	}
+	if value >= 1<<55 {
+		return UrlId{}, false
+	}
+	return idFromValue(value), true
+}
+
+// codecChain encodes with its first codec and decodes with any of them, so that short
+// URLs made with a previous codec keep working after switching to another
+type codecChain []Codec
+
+var defaultCodecs = codecChain{base62Codec{}}
+
+// newCodecChain builds the chain for the named codec, followed by those it replaced
+func newCodecChain(name string, previous []string) (codecChain, error) {
+	chain := codecChain{}
+	for _, n := range append([]string{name}, previous...) {
+		if strings.TrimSpace(n) == "" {
+			continue
+		}
+		codec, err := codecByName(n)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, codec)
+	}
+	if len(chain) == 0 {
+		return defaultCodecs, nil
+	}
+	return chain, nil
+}
+
+func (cc codecChain) encode(id UrlId) string {
+	return cc[0].Encode(id)
+}
+
+// decode returns the ids that code could stand for, by the codecs in order. A code can
+// be valid in more than one, as base58 codes also have the shape of base62 ones.
+func (cc codecChain) decode(code string) []UrlId {
+	var ids []UrlId
+	for _, codec := range cc {
+		if id, ok := codec.Decode(code); ok && !containsId(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func containsId(ids []UrlId, id UrlId) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	ids := []UrlId{{}, {0xff, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xff}}
+	for _, seq := range []uint32{0, 1, 42, 1<<23 - 1} {
+		id := UrlId{}
+		_ = encodeID(&id, 1714085905, seq)
+		ids = append(ids, id)
+	}
+
+	for name, codec := range codecs {
+		for _, id := range ids {
+			code := codec.Encode(id)
+			if decoded, ok := codec.Decode(code); !ok || decoded != id {
+				t.Errorf("%s: %x encodes to %s, which decodes to %x", name, id[:], code, decoded[:])
+			}
+		}
+	}
+}
+
+func TestCrockford32Codec_Decode(t *testing.T) {
+	id := UrlId{}
+	_ = encodeID(&id, 1714085905, 42)
+	code := crockford32Codec{}.Encode(id)
+	if len(code) != crockford32Len || strings.ContainsAny(code, "ILOU") {
+		t.Fatalf("Unexpected code %q", code)
+	}
+
+	typed := strings.ToLower(code[:4]) + "-" + code[4:]
+	if decoded, ok := (crockford32Codec{}).Decode(typed); !ok || decoded != id {
+		t.Errorf("Expected %q to decode regardless of case and hyphens", typed)
+	}
+	if a, _ := (crockford32Codec{}).Decode("0000000000I"); a != idFromValue(1) {
+		t.Error("I should be read as 1")
+	}
+	if a, _ := (crockford32Codec{}).Decode("000000000O1"); a != idFromValue(1) {
+		t.Error("O should be read as 0")
+	}
+	if _, ok := (crockford32Codec{}).Decode("0000000000U"); ok {
+		t.Error("U isn't in the alphabet")
+	}
+}
+
+func TestBase58Codec_Decode_OutOfRange(t *testing.T) {
+	if _, ok := (base58Codec{}).Decode("zzzzzzzzzz"); ok {
+		t.Error("Codes above 55 bits should be rejected")
+	}
+	if _, ok := (base58Codec{}).Decode("0000000001"); ok {
+		t.Error("0 isn't in the alphabet")
+	}
+}
+
+func TestBase62Codec_Decode_NonCanonical(t *testing.T) {
+	if _, ok := (base62Codec{}).Decode("zzzzzzzzzz"); ok {
+		t.Error("Codes that don't round trip should be rejected")
+	}
+}
+
+func TestNewCodecChain(t *testing.T) {
+	chain, err := newCodecChain("Crockford32", []string{" base62", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || chain[0].Name() != "crockford32" || chain[1].Name() != "base62" {
+		t.Errorf("Unexpected chain %v", chain)
+	}
+	if chain, _ = newCodecChain("", nil); chain[0].Name() != "base62" {
+		t.Error("Expected base62 by default")
+	}
+	if _, err = newCodecChain("base64", nil); err == nil {
+		t.Error("Expected an error for an unknown codec")
+	}
+}
+
+func TestServer_Redirect_PreviousCodec(t *testing.T) {
+	s := newTestServer(t)
+	oldUrl := shortenForTest(t, s, "https://example.com/old")
+
+	s.app.codes = codecChain{crockford32Codec{}, base62Codec{}}
+	newUrl := shortenForTest(t, s, "https://example.com/new")
+	if len(newUrl) != crockford32Len {
+		t.Errorf("Expected a crockford32 code, got %q", newUrl)
+	}
+
+	for shortUrl, longUrl := range map[string]string{oldUrl: "https://example.com/old", newUrl: "https://example.com/new"} {
+		rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+shortUrl, nil)
+		if rec.Code != http.StatusOK || decodeBody(t, rec)["longUrl"] != longUrl {
+			t.Errorf("Expected %s to lead to %s, got %d: %s", shortUrl, longUrl, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type config struct {
+	port              string
+	dsn               string
+	baseURL           string // Public origin that short URLs are served from
+	idempotencyWindow time.Duration
+	cookieSecret      []byte        // Signs unlock cookies for password protected links
+	unlockTTL         time.Duration // How long an entered password is remembered
+	geoIPPath         string        // MaxMind format database for locating visitors, optional
+	geoIPReload       time.Duration // How often to check the database file for changes
+	trustedProxies    []string      // Addresses or CIDRs whose X-Forwarded-For is believed, none by default
+	adminToken        []byte        // Bearer token for admin only details and exports, which are off without one
+	codecs            codecChain    // Encodes ids into short URLs, decoding with previous codecs too
+	idGenerator       string        // timestamp or hilo, or empty for the one suiting the codec
+	idBlockSize       uint64        // Counter ids reserved at a time by the hilo generator
+}
+
+func defaultConfig() config {
+	return config{
+		port:              "8080",
+		dsn:               "root@tcp(db:3306)/urlshortener",
+		baseURL:           "http://localhost:8080",
+		idempotencyWindow: 24 * time.Hour,
+		unlockTTL:         15 * time.Minute,
+		geoIPReload:       time.Minute,
+		codecs:            defaultCodecs,
+		idBlockSize:       defaultIdBlockSize,
+	}
+}
+
+// configFromEnv overlays any settings found in the environment onto the defaults
+func configFromEnv() (config, error) {
+	cfg := defaultConfig()
+
+	if port := os.Getenv("PORT"); port != "" {
+		cfg.port = port
+	}
+	if dsn := os.Getenv("DATABASE_DSN"); dsn != "" {
+		cfg.dsn = dsn
+	}
+	if baseURL := os.Getenv("BASE_URL"); baseURL != "" {
+		cfg.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+	if err := envDuration("IDEMPOTENCY_WINDOW", &cfg.idempotencyWindow); err != nil {
+		return config{}, err
+	}
+	if err := envDuration("UNLOCK_TTL", &cfg.unlockTTL); err != nil {
+		return config{}, err
+	}
+	cfg.adminToken = []byte(os.Getenv("ADMIN_TOKEN"))
+	var previous []string
+	if raw := os.Getenv("SHORT_CODE_PREVIOUS_CODECS"); raw != "" {
+		previous = strings.Split(raw, ",")
+	}
+	codecs, err := newCodecChain(os.Getenv("SHORT_CODE_CODEC"), previous)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid SHORT_CODE_CODEC or SHORT_CODE_PREVIOUS_CODECS: %w", err)
+	}
+	cfg.codecs = codecs
+	switch cfg.idGenerator = strings.ToLower(os.Getenv("ID_GENERATOR")); cfg.idGenerator {
+	case "", generatorTimestamp, generatorHiLo:
+	default:
+		return config{}, fmt.Errorf("invalid ID_GENERATOR %q, expected %s or %s", cfg.idGenerator, generatorTimestamp, generatorHiLo)
+	}
+	if raw := os.Getenv("ID_BLOCK_SIZE"); raw != "" {
+		size, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || size < 1 || size > 1000000 {
+			return config{}, fmt.Errorf("invalid ID_BLOCK_SIZE %q, expected between 1 and 1000000", raw)
+		}
+		cfg.idBlockSize = size
+	}
+	cfg.geoIPPath = os.Getenv("GEOIP_DB")
+	if raw := os.Getenv("TRUSTED_PROXIES"); raw != "" {
+		for _, proxy := range strings.Split(raw, ",") {
+			cfg.trustedProxies = append(cfg.trustedProxies, strings.TrimSpace(proxy))
+		}
+	}
+	if err := envDuration("GEOIP_RELOAD_INTERVAL", &cfg.geoIPReload); err != nil {
+		return config{}, err
+	}
+
+	// Without a configured secret, unlock cookies only work on this instance until it restarts
+	if secret := os.Getenv("COOKIE_SECRET"); secret != "" {
+		cfg.cookieSecret = []byte(secret)
+	} else {
+		cfg.cookieSecret = make([]byte, 32)
+		if _, err := rand.Read(cfg.cookieSecret); err != nil {
+			return config{}, err
+		}
+	}
+
+	return cfg, nil
+}
+
+func envDuration(name string, dst *time.Duration) error {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dst = d
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Counter ids are numbered from a sequence kept in the database, rather than made from
+// the time as encodeID does. They are the values below 2^47 packed as idFromValue does,
+// which leaves the first byte zero. That would be the high byte of a timestamp in 1970,
+// so counter ids can't collide with timestamp ids, and sort before all of them.
+const counterIdLimit = 1 << 47
+
+// linkSequence is the sequence in the database that counter ids for links come from
+const linkSequence = "links"
+
+// firstCounter is where linkSequence starts, so that compact codes start at 4
+// characters. Migration 0010 starts it at the same value.
+const firstCounter = 62 * 62 * 62
+
+func counterId(n uint64) UrlId {
+	return idFromValue(n)
+}
+
+func isCounterId(id UrlId) bool {
+	return id[0] == 0
+}
+
+// reserveIdsTo moves sequence in db up to next, if it isn't there already, so that it
+// won't hand out ids below next
+func reserveIdsTo(db UrlDB, sequence string, next uint64) error {
+	taken, err := db.ReserveIds(sequence, 0)
+	if err != nil || taken >= next {
+		return err
+	}
+	_, err = db.ReserveIds(sequence, next-taken)
+	return err
+}
+
+const compactMinLen = 4
+const compactMaxLen = 8 // 62^8 is more than counterIdLimit
+
+// compactCodec encodes counter ids as the fewest base62 characters their value takes, at
+// least compactMinLen, so that codes start short and grow with the number of links.
+// Timestamp ids are encoded as base62Codec does, which no compact code is as long as.
+type compactCodec struct{}
+
+func (compactCodec) Name() string {
+	return "compact"
+}
+
+func (compactCodec) Encode(id UrlId) string {
+	if !isCounterId(id) {
+		return encodeBase62(id)
+	}
+	value := idValue(id)
+	code := make([]byte, 0, compactMaxLen)
+	for value > 0 || len(code) < compactMinLen {
+		code = append(code, base62Chars[value%62])
+		value /= 62
+	}
+	for i, j := 0, len(code)-1; i < j; i, j = i+1, j-1 {
+		code[i], code[j] = code[j], code[i]
+	}
+	return string(code)
+}
+
+// Decode only accepts the code an id encodes to, so not a counter id padded with zeros
+// or written out in 10 characters
+func (c compactCodec) Decode(code string) (UrlId, bool) {
+	if len(code) == shortURLLen {
+		id, ok := base62Codec{}.Decode(code)
+		return id, ok && !isCounterId(id)
+	}
+	if len(code) < compactMinLen || len(code) > compactMaxLen {
+		return UrlId{}, false
+	}
+	var value uint64
+	for i := 0; i < len(code); i++ {
+		digit := strings.IndexByte(base62Chars, code[i])
+		if digit == -1 {
+			return UrlId{}, false
+		}
+		value = value*62 + uint64(digit)
+	}
+	if value >= counterIdLimit {
+		return UrlId{}, false
+	}
+	id := counterId(value)
+	return id, c.Encode(id) == code
+}
+
+// Generators that ids can be made by, as ID_GENERATOR names them
+const (
+	generatorTimestamp = "timestamp"
+	generatorHiLo      = "hilo"
+)
+
+const defaultIdBlockSize = 100
+
+// idBlock is a range of counter ids reserved from the database, from first up to end
+type idBlock struct {
+	first uint64
+	end   uint64
+}
+
+// counterIDGenerator hands out counter ids with the hi/lo scheme: blocks of ids are
+// reserved from a sequence in the database, which instances sharing it never get the
+// same ones of, and ids within a block are handed out without going to the database.
+// The next block is reserved in the background once a fifth of the current one is
+// left, so requests only wait on the database if ids are taken faster than that. Ids
+// left in a block when an instance stops are never used, which only makes codes grow a
+// little sooner.
+type counterIDGenerator struct {
+	db        UrlDB
+	blockSize uint64
+	refillAt  uint64 // Ids left in the current block when the next is reserved
+	next      uint64
+	end       uint64   // The first id past the current block
+	spare     *idBlock // The next block, once reserved
+	refilling bool
+	err       error // From the last reservation, for whoever is waiting on it
+	lock      sync.Mutex
+	cond      *sync.Cond
+}
+
+func newCounterIDGenerator(db UrlDB, blockSize uint64) *counterIDGenerator {
+	g := &counterIDGenerator{db: db, blockSize: blockSize, refillAt: blockSize / 5}
+	g.cond = sync.NewCond(&g.lock)
+	return g
+}
+
+func (g *counterIDGenerator) GenerateUniqueID() (UrlId, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for g.next == g.end {
+		if g.spare != nil {
+			g.next, g.end = g.spare.first, g.spare.end
+			g.spare = nil
+			break
+		}
+		if !g.refilling {
+			g.refill()
+		}
+		g.cond.Wait()
+		if err := g.err; err != nil {
+			g.err = nil // So the next caller tries again
+			return UrlId{}, fmt.Errorf("failed to reserve ids: %w", err)
+		}
+	}
+	if g.next >= counterIdLimit {
+		return UrlId{}, fmt.Errorf("counter ids are used up")
+	}
+	id := counterId(g.next)
+	g.next++
+	if g.end-g.next <= g.refillAt && g.spare == nil && !g.refilling {
+		g.refill()
+	}
+	return id, nil
+}
+
+// refill reserves the next block in the background, and must be called with the lock
+// held. A failure is only reported to callers waiting on it; otherwise it's tried again
+// once the current block runs out.
+func (g *counterIDGenerator) refill() {
+	g.refilling = true
+	g.err = nil
+	go func() {
+		first, err := g.db.ReserveIds(linkSequence, g.blockSize)
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		g.refilling = false
+		if err != nil {
+			g.err = err
+		} else {
+			g.spare = &idBlock{first: first, end: first + g.blockSize}
+		}
+		g.cond.Broadcast()
+	}()
+}
+
+// newIDGenerator returns the generator cfg names, or else the one for the ids that its
+// codec is meant for
+func newIDGenerator(cfg config, db UrlDB) UniqueIDGenerator {
+	name := cfg.idGenerator
+	if name == "" && len(cfg.codecs) > 0 && cfg.codecs[0].Name() == (compactCodec{}).Name() {
+		name = generatorHiLo
+	}
+	if name == generatorHiLo {
+		return newCounterIDGenerator(db, cfg.idBlockSize)
+	}
+	return newUniqueIDGenerator()
+}
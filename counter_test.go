@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompactCodec_Encode(t *testing.T) {
+	for n, expected := range map[uint64]string{0: "0000", 61: "000z", firstCounter: "1000", counterIdLimit - 1: "dxlGgaI3"} {
+		if code := (compactCodec{}).Encode(counterId(n)); code != expected {
+			t.Errorf("Expected %d to encode to %s, got %s", n, expected, code)
+		}
+	}
+
+	id := UrlId{}
+	_ = encodeID(&id, 1714085905, 42)
+	if code := (compactCodec{}).Encode(id); code != encodeBase62(id) {
+		t.Errorf("Expected a timestamp id to keep its 10 character code, got %s", code)
+	}
+	if decoded, ok := (compactCodec{}).Decode(encodeBase62(id)); !ok || decoded != id {
+		t.Error("Expected a 10 character code to decode as base62")
+	}
+}
+
+func TestCompactCodec_Decode_NonCanonical(t *testing.T) {
+	for _, code := range []string{"01000", "0000001000", "100", "zzzzzzzz", "10-0"} {
+		if id, ok := (compactCodec{}).Decode(code); ok {
+			t.Errorf("Expected %q to be rejected, got %x", code, id[:])
+		}
+	}
+}
+
+func TestCounterIDGenerator_SharedSequence(t *testing.T) {
+	db := &InMemoryUrlDb{}
+	first, second := newCounterIDGenerator(db, 3), newCounterIDGenerator(db, 3)
+
+	seen := make(map[UrlId]bool)
+	for i := 0; i < 10; i++ {
+		for _, g := range []*counterIDGenerator{first, second} {
+			id, err := g.GenerateUniqueID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[id] || !isCounterId(id) {
+				t.Fatalf("Unexpected id %x", id[:])
+			}
+			seen[id] = true
+		}
+	}
+	if next, _ := db.ReserveIds(linkSequence, 0); next != firstCounter+24 {
+		t.Errorf("Expected 8 blocks of 3 to be reserved, the sequence is at %d", next)
+	}
+}
+
+// reservingUrlDb reports each block of ids reserved, and can be made to fail reserving
+type reservingUrlDb struct {
+	*InMemoryUrlDb
+	reserved chan uint64
+	fail     bool
+}
+
+func (r *reservingUrlDb) ReserveIds(sequence string, count uint64) (uint64, error) {
+	if r.fail {
+		return 0, errors.New("connection refused")
+	}
+	first, err := r.InMemoryUrlDb.ReserveIds(sequence, count)
+	r.reserved <- first
+	return first, err
+}
+
+func TestCounterIDGenerator_RefillsAhead(t *testing.T) {
+	db := &reservingUrlDb{InMemoryUrlDb: &InMemoryUrlDb{}, reserved: make(chan uint64, 2)}
+	g := newCounterIDGenerator(db, 10)
+
+	for i := 0; i < 8; i++ {
+		if id, err := g.GenerateUniqueID(); err != nil || idValue(id) != firstCounter+uint64(i) {
+			t.Fatalf("Unexpected id %x, %v", id[:], err)
+		}
+	}
+	for _, expected := range []uint64{firstCounter, firstCounter + 10} {
+		select {
+		case first := <-db.reserved:
+			if first != expected {
+				t.Errorf("Expected a block from %d, got one from %d", expected, first)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the next block to be reserved with 2 ids left")
+		}
+	}
+}
+
+func TestCounterIDGenerator_ReserveFails(t *testing.T) {
+	db := &reservingUrlDb{InMemoryUrlDb: &InMemoryUrlDb{}, reserved: make(chan uint64, 10), fail: true}
+	g := newCounterIDGenerator(db, 5)
+	if _, err := g.GenerateUniqueID(); err == nil {
+		t.Fatal("Expected an error without a block")
+	}
+
+	db.fail = false
+	if id, err := g.GenerateUniqueID(); err != nil || idValue(id) != firstCounter {
+		t.Errorf("Expected the next call to reserve again, got %x, %v", id[:], err)
+	}
+}
+
+func TestCounterIDGenerator_Concurrent(t *testing.T) {
+	g := newCounterIDGenerator(&InMemoryUrlDb{}, 7)
+	ids := make(chan UrlId, 400)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id, err := g.GenerateUniqueID()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[UrlId]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("Generator produced %x twice", id[:])
+		}
+		seen[id] = true
+	}
+}
+
+func TestServer_Shorten_Compact(t *testing.T) {
+	s := newTestServer(t)
+	timestampUrl := shortenForTest(t, s, "https://example.com/old")
+
+	s.app.codes = codecChain{compactCodec{}}
+	s.app.idGenerator = newCounterIDGenerator(s.db, defaultIdBlockSize)
+	compactUrl := shortenForTest(t, s, "https://example.com/new")
+	if compactUrl != "1000" {
+		t.Errorf("Expected the first compact code, got %q", compactUrl)
+	}
+
+	for shortUrl, longUrl := range map[string]string{timestampUrl: "https://example.com/old", compactUrl: "https://example.com/new"} {
+		rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+shortUrl, nil)
+		if rec.Code != http.StatusOK || decodeBody(t, rec)["longUrl"] != longUrl {
+			t.Errorf("Expected %s to lead to %s, got %d: %s", shortUrl, longUrl, rec.Code, rec.Body.String())
+		}
+	}
+
+	links := listLinksForTest(t, s, "?createdBefore=2999-01-01T00:00:00Z")
+	if len(links) != 1 || links[0].ShortUrl != timestampUrl {
+		t.Errorf("Expected only the link with a time to be listed, got %+v", links)
+	}
+	rec := doRequest(s, http.MethodGet, "/api/v1/links", nil)
+	if strings.Count(rec.Body.String(), `"createdAt"`) != 1 {
+		t.Errorf("Expected only the link with a time to have createdAt, got %s", rec.Body.String())
+	}
+	patchLink(s, compactUrl, `{"title": "New"}`, "")
+	rec = doRequest(s, http.MethodGet, "/api/v1/links/"+compactUrl+"/history", asAdmin())
+	if strings.Count(rec.Body.String(), `"changedAt"`) != 1 {
+		t.Errorf("Expected the first version to have no changedAt, got %s", rec.Body.String())
+	}
+	if info := newIdInfo(counterId(firstCounter), compactUrl); info.Format != idFormatCounter || info.Sequence != firstCounter || info.CreatedAt != nil {
+		t.Errorf("Unexpected info %+v", info)
+	}
+}
+
+func TestServer_Shorten_SkipsTakenId(t *testing.T) {
+	s := newTestServer(t)
+	s.app.codes = codecChain{compactCodec{}}
+	s.app.idGenerator = newCounterIDGenerator(s.db, defaultIdBlockSize)
+	if err := s.db.StoreLink(Link{Id: counterId(firstCounter), LongUrl: "https://example.com/imported"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if shortUrl := shortenForTest(t, s, "https://example.com/new"); shortUrl != "1001" {
+		t.Errorf("Expected the taken id to be skipped, got %q", shortUrl)
+	}
+}
+
+func TestServer_Import_AdvancesSequence(t *testing.T) {
+	s := newTestServer(t)
+	s.app.codes = codecChain{compactCodec{}}
+	s.app.idGenerator = newCounterIDGenerator(s.db, defaultIdBlockSize)
+
+	body := `{"shortUrl": "1005", "longUrl": "https://example.com/imported"}` + "\n"
+	if rec := postImport(s, "application/x-ndjson", body, asAdmin()); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from import, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if shortUrl := shortenForTest(t, s, "https://example.com/new"); shortUrl != "1006" {
+		t.Errorf("Expected new links to follow the imported one, got %q", shortUrl)
+	}
+}
+
+func TestServer_Import_CounterIdTooFarAhead(t *testing.T) {
+	s := newTestServer(t)
+	s.app.codes = codecChain{compactCodec{}}
+	s.app.idGenerator = newCounterIDGenerator(s.db, defaultIdBlockSize)
+
+	last := (compactCodec{}).Encode(counterId(counterIdLimit - 1))
+	rec := postImport(s, "application/x-ndjson", `{"shortUrl": "`+last+`", "longUrl": "https://example.com/imported"}`+"\n", asAdmin())
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"failed":1`) {
+		t.Fatalf("Expected the import of the last counter id to fail, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if shortUrl := shortenForTest(t, s, "https://example.com/new"); shortUrl != "1000" {
+		t.Errorf("Expected the sequence to be left alone, got %q", shortUrl)
+	}
+}
+
+func TestServer_Shorten_SkipsReservedCodes(t *testing.T) {
+	s := newTestServer(t)
+	s.app.codes = codecChain{compactCodec{}}
+	s.app.idGenerator = newCounterIDGenerator(s.db, defaultIdBlockSize)
+	live, _ := (compactCodec{}).Decode("livez")
+	if _, err := s.db.ReserveIds(linkSequence, idValue(live)-firstCounter); err != nil {
+		t.Fatal(err)
+	}
+
+	shortUrl := shortenForTest(t, s, "https://example.com")
+	if shortUrl != (compactCodec{}).Encode(counterId(idValue(live)+1)) {
+		t.Errorf("Expected the code after livez, got %q", shortUrl)
+	}
+	if rec := doRequest(s, http.MethodGet, "/livez", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected the liveness probe to be left alone, got %d", rec.Code)
+	}
+
+	rec := postImport(s, "application/x-ndjson", `{"shortUrl": "readyz", "longUrl": "https://example.com/ready"}`+"\n", asAdmin())
+	if !strings.Contains(rec.Body.String(), `"failed":1`) {
+		t.Errorf("Expected a reserved short URL not to be imported, got %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// dataMigration copies links, with their clicks and history, and campaigns from one
+// store to another. Links are read in id order, a batch at a time, and written by
+// several workers at once. Click events aren't copied, so the breakdowns by variant and
+// country start over in the new store; the click totals carry across.
+type dataMigration struct {
+	from       UrlDB
+	to         UrlDB
+	codecs     codecChain // What short URLs are reported as, while the checkpoint is base62
+	workers    int
+	batchSize  int
+	checkpoint string                       // File recording how far the copy got, optional
+	onProgress func(copied int, last UrlId) // Called as each batch is copied, in order
+}
+
+type migrationBatch struct {
+	seq   int
+	links []Link
+}
+
+type migrationResult struct {
+	seq   int
+	count int
+	last  UrlId
+	err   error
+}
+
+func newDataMigration(from UrlDB, to UrlDB) *dataMigration {
+	return &dataMigration{
+		from:       from,
+		to:         to,
+		codecs:     defaultCodecs,
+		workers:    4,
+		batchSize:  defaultImportBatch,
+		onProgress: func(int, UrlId) {},
+	}
+}
+
+// copy copies everything after the checkpoint, returning how many links it copied.
+// The checkpoint only ever moves past batches that are copied along with all before
+// them, so a copy that stops can be run again to resume. Links copied past the
+// checkpoint are found in the target then and left as they are.
+func (dm *dataMigration) copy() (int, error) {
+	after, err := dm.readCheckpoint()
+	if err != nil {
+		return 0, err
+	}
+	campaigns, err := dm.from.ListCampaigns()
+	if err != nil {
+		return 0, err
+	}
+	for _, campaign := range campaigns {
+		if err = dm.to.StoreCampaign(campaign); err != nil {
+			return 0, err
+		}
+	}
+	if err = dm.copySequence(linkSequence); err != nil {
+		return 0, err
+	}
+
+	batches := make(chan migrationBatch)
+	results := make(chan migrationResult)
+	stop := make(chan struct{})
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		filter := LinkFilter{After: after, Limit: dm.batchSize}
+		for seq := 0; ; seq++ {
+			links, err := dm.from.ListLinks(filter)
+			if err != nil || len(links) == 0 {
+				readErr <- err
+				return
+			}
+			select {
+			case batches <- migrationBatch{seq: seq, links: links}:
+			case <-stop:
+				readErr <- nil
+				return
+			}
+			filter.After = links[len(links)-1].Id
+		}
+	}()
+
+	workers := make(chan struct{}, dm.workers)
+	for i := 0; i < dm.workers; i++ {
+		go func() {
+			defer func() { workers <- struct{}{} }()
+			for batch := range batches {
+				results <- migrationResult{
+					seq:   batch.seq,
+					count: len(batch.links),
+					last:  batch.links[len(batch.links)-1].Id,
+					err:   dm.copyBatch(batch.links),
+				}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < dm.workers; i++ {
+			<-workers
+		}
+		close(results)
+	}()
+
+	// Batches finish out of order, so hold on to each until those before it are done
+	var firstErr error
+	fail := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+			close(stop)
+		}
+	}
+	finished := make(map[int]migrationResult)
+	next, copied := 0, 0
+	for result := range results {
+		if result.err != nil {
+			fail(result.err)
+			continue
+		}
+		finished[result.seq] = result
+		for firstErr == nil {
+			done, exists := finished[next]
+			if !exists {
+				break
+			}
+			delete(finished, next)
+			next++
+			copied += done.count
+			if err := dm.writeCheckpoint(done.last); err != nil {
+				fail(err)
+				break
+			}
+			dm.onProgress(copied, done.last)
+		}
+	}
+	if err := <-readErr; err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return copied, firstErr
+}
+
+// copySequence moves the target's sequence up to the source's, so that the target won't
+// hand out counter ids that were copied to it. Ids the source hands out during the copy
+// are caught up with by copying again once it has stopped.
+func (dm *dataMigration) copySequence(sequence string) error {
+	next, err := dm.from.ReserveIds(sequence, 0)
+	if err != nil {
+		return err
+	}
+	return reserveIdsTo(dm.to, sequence, next)
+}
+
+// copyBatch stores links in one go, unless some of them are already in the target
+func (dm *dataMigration) copyBatch(links []Link) error {
+	err := dm.to.StoreLinks(links)
+	if errors.Is(err, ErrDuplicateURL) || errors.Is(err, ErrIdTaken) {
+		for _, link := range links {
+			if err = dm.copyLink(link); err != nil {
+				return err
+			}
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		if err = dm.copyHistory(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyLink stores a link on its own, accepting one that was copied before
+func (dm *dataMigration) copyLink(link Link) error {
+	err := dm.to.StoreLinks([]Link{link})
+	if errors.Is(err, ErrDuplicateURL) {
+		return fmt.Errorf("%s conflicts with a link already in the target: %w", dm.codecs.encode(link.Id), err)
+	}
+	if !errors.Is(err, ErrIdTaken) {
+		return err
+	}
+	existing, err := dm.to.GetLink(link.Id)
+	if err != nil {
+		return err
+	}
+	if existing.LongUrl != link.LongUrl {
+		return fmt.Errorf("%s conflicts with a link already in the target", dm.codecs.encode(link.Id))
+	}
+	return nil
+}
+
+// copyHistory adds the versions of a link that the target doesn't have yet
+func (dm *dataMigration) copyHistory(link Link) error {
+	history, err := dm.from.LinkHistory(link.Id)
+	if err != nil || len(history) == 0 {
+		return err
+	}
+	copied, err := dm.to.LinkHistory(link.Id)
+	if err != nil || len(copied) >= len(history) {
+		return err
+	}
+	return dm.to.UpdateLink(link, history[len(copied):])
+}
+
+// readCheckpoint returns the id of the last link copied, zeroed out without a checkpoint
+func (dm *dataMigration) readCheckpoint() (UrlId, error) {
+	if dm.checkpoint == "" {
+		return UrlId{}, nil
+	}
+	raw, err := os.ReadFile(dm.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return UrlId{}, nil
+	}
+	if err != nil {
+		return UrlId{}, err
+	}
+	shortUrl := strings.TrimSpace(string(raw))
+	if !isShortUrl(shortUrl) {
+		return UrlId{}, fmt.Errorf("malformed checkpoint %s: %q is not a short URL", dm.checkpoint, shortUrl)
+	}
+	return decodeBase62(shortUrl), nil
+}
+
+// writeCheckpoint replaces the checkpoint by renaming, so a crash can't leave half of one
+func (dm *dataMigration) writeCheckpoint(last UrlId) error {
+	if dm.checkpoint == "" {
+		return nil
+	}
+	tmp := dm.checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, []byte(encodeBase62(last)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dm.checkpoint)
+}
+
+// migrationReport is the outcome of verifying a copy
+type migrationReport struct {
+	sourceLinks int
+	targetLinks int
+	sampled     int
+	mismatched  []string // Short URLs of sampled links that differ, with how
+}
+
+func (r migrationReport) ok() bool {
+	return r.sourceLinks == r.targetLinks && len(r.mismatched) == 0
+}
+
+// verify compares the number of links in both stores, and up to samples links chosen at
+// random from the source with their counterparts in the target. Clicks made on the
+// source during the copy show up as mismatches.
+func (dm *dataMigration) verify(samples int) (migrationReport, error) {
+	var report migrationReport
+	var sample []UrlId
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	err := forEachLink(dm.from, func(link Link) error { // Reservoir sampling, in one pass
+		report.sourceLinks++
+		if len(sample) < samples {
+			sample = append(sample, link.Id)
+		} else if i := random.Intn(report.sourceLinks); i < samples {
+			sample[i] = link.Id
+		}
+		return nil
+	})
+	if err != nil {
+		return migrationReport{}, err
+	}
+	err = forEachLink(dm.to, func(Link) error {
+		report.targetLinks++
+		return nil
+	})
+	if err != nil {
+		return migrationReport{}, err
+	}
+
+	for _, id := range sample {
+		difference, err := dm.compare(id)
+		if err != nil {
+			return migrationReport{}, err
+		}
+		if difference != "" {
+			report.mismatched = append(report.mismatched, dm.codecs.encode(id)+": "+difference)
+		}
+	}
+	report.sampled = len(sample)
+	return report, nil
+}
+
+// compare describes how a link differs between the stores, empty if it doesn't
+func (dm *dataMigration) compare(id UrlId) (string, error) {
+	source, err := dm.from.GetLink(id)
+	if err != nil {
+		return "", err
+	}
+	target, err := dm.to.GetLink(id)
+	if err != nil {
+		return "", err
+	}
+	if !target.Exists() {
+		return "missing", nil
+	}
+	if target.LongUrl != source.LongUrl {
+		return "long URL differs", nil
+	}
+	if target.Clicks != source.Clicks {
+		return fmt.Sprintf("%d clicks, %d in the source", target.Clicks, source.Clicks), nil
+	}
+	sourceSettings, err := json.Marshal(source.Settings)
+	if err != nil {
+		return "", err
+	}
+	targetSettings, err := json.Marshal(target.Settings)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.Equal(sourceSettings, targetSettings) {
+		return "settings differ", nil
+	}
+	sourceHistory, err := dm.from.LinkHistory(id)
+	if err != nil {
+		return "", err
+	}
+	targetHistory, err := dm.to.LinkHistory(id)
+	if err != nil {
+		return "", err
+	}
+	if len(targetHistory) != len(sourceHistory) {
+		return fmt.Sprintf("%d versions, %d in the source", len(targetHistory), len(sourceHistory)), nil
+	}
+	return "", nil
+}
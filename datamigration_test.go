@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// migrationSource stores n links, some of them clicked and updated
+func migrationSource(t *testing.T, n int) *InMemoryUrlDb {
+	db := &InMemoryUrlDb{}
+	for i := 0; i < n; i++ {
+		link := Link{LongUrl: fmt.Sprintf("https://example.com/%d", i), Clicks: uint64(i % 3)}
+		_ = encodeID(&link.Id, 1714085905, uint32(i))
+		if i%4 == 0 {
+			link.Settings.Tags = []string{"even"}
+		}
+		if err := db.StoreLinks([]Link{link}); err != nil {
+			t.Fatal(err)
+		}
+		if i%5 == 0 {
+			updated := Link{Id: link.Id, LongUrl: link.LongUrl + "/v2", Settings: link.Settings}
+			versions := []LinkVersion{{Version: 1, LongUrl: link.LongUrl}, {Version: 2, LongUrl: updated.LongUrl}}
+			if err := db.UpdateLink(updated, versions); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.StoreCampaign(Campaign{Name: "spring", UTM: UTM{Source: "newsletter"}}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDataMigration_Copy(t *testing.T) {
+	source, target := migrationSource(t, 25), &InMemoryUrlDb{}
+	dm := newDataMigration(source, target)
+	dm.batchSize, dm.workers = 4, 3
+
+	copied, err := dm.copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 25 {
+		t.Errorf("Expected 25 links copied, got %d", copied)
+	}
+	report, err := dm.verify(25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.ok() || report.sampled != 25 {
+		t.Errorf("Expected every link to match, got %+v", report)
+	}
+	if campaign, _ := target.GetCampaign("spring"); campaign.UTM.Source != "newsletter" {
+		t.Error("Campaigns should be copied")
+	}
+}
+
+func TestDataMigration_Resume(t *testing.T) {
+	source, target := migrationSource(t, 10), &InMemoryUrlDb{}
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+	dm := newDataMigration(source, target)
+	dm.batchSize, dm.checkpoint = 3, checkpoint
+	if _, err := dm.copy(); err != nil {
+		t.Fatal(err)
+	}
+	last, _ := dm.readCheckpoint()
+	if last != source.records[9].id {
+		t.Errorf("Expected the checkpoint at the last link, got %s", encodeBase62(last))
+	}
+
+	// As if the copy had stopped after the fourth link with later ones already written
+	if err := dm.writeCheckpoint(source.records[3].id); err != nil {
+		t.Fatal(err)
+	}
+	extra := Link{LongUrl: "https://example.com/late"}
+	_ = encodeID(&extra.Id, 1714085906, 0)
+	if err := source.StoreLink(extra); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := dm.copy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 7 {
+		t.Errorf("Expected the 7 links after the checkpoint to be gone through, got %d", copied)
+	}
+	if report, err := dm.verify(10); err != nil || !report.ok() {
+		t.Errorf("Expected the resumed copy to match, got %+v, %v", report, err)
+	}
+}
+
+func TestDataMigration_Conflict(t *testing.T) {
+	source, target := migrationSource(t, 3), &InMemoryUrlDb{}
+	if err := target.StoreURLRecord(UrlId{9, 9, 9, 9, 9}, source.records[1].longUrl); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newDataMigration(source, target).copy(); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("Expected a conflict with the link already in the target, got %v", err)
+	}
+}
+
+func TestCLI_MigrateData(t *testing.T) {
+	stores := map[string]*InMemoryUrlDb{"source": migrationSource(t, 5), "target": {}}
+	stderr := &bytes.Buffer{}
+	c := &cli{
+		stdout: &bytes.Buffer{},
+		stderr: stderr,
+		cfg:    defaultConfig(),
+		openDB: func(dsn string) (UrlDB, func(), error) {
+			return stores[dsn], func() {}, nil
+		},
+	}
+
+	if code := c.run([]string{"migrate-data", "-from", "mysql:source", "-to", "mysql:target"}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d: %s", exitOK, code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "5 links in the source, 5 in the target, 5 of 5 sampled links match") {
+		t.Errorf("Unexpected report %q", stderr.String())
+	}
+
+	for _, args := range [][]string{{"-from", "mysql:source"}, {"-from", "source", "-to", "mysql:target"}, {"-from", "mongo:x", "-to", "mysql:target"}} {
+		if code := c.run(append([]string{"migrate-data"}, args...)); code != exitUsage {
+			t.Errorf("Expected exit code %d for %v, got %d", exitUsage, args, code)
+		}
+	}
+}
+
+func TestDataMigration_ConflictCodec(t *testing.T) {
+	source, target := &InMemoryUrlDb{}, &InMemoryUrlDb{}
+	if err := source.StoreLinks([]Link{{Id: counterId(firstCounter), LongUrl: "https://example.com/a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.StoreURLRecord(UrlId{9, 9, 9, 9, 9}, "https://example.com/a"); err != nil {
+		t.Fatal(err)
+	}
+	dm := newDataMigration(source, target)
+	dm.codecs = codecChain{compactCodec{}}
+	if _, err := dm.copy(); err == nil || !strings.HasPrefix(err.Error(), "1000 conflicts") {
+		t.Errorf("Expected the conflict to be reported by the compact code, got %v", err)
+	}
+}
@@ -1,17 +1,47 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// ErrDuplicateURL is returned by StoreLink when the long URL is already stored
+var ErrDuplicateURL = errors.New("long URL is already stored")
+
+// ErrIdTaken is returned by StoreLink and MoveLink when there is already a link with the id
+var ErrIdTaken = errors.New("id is already taken")
+
+const mysqlErrDupEntry = 1062
+
 type UrlDB interface {
-	GetId(longUrl string) (UrlId, error) // Zeroed out if not found
-	GetLongURL(id UrlId) (string, error) // Empty string if not found
-	StoreURLRecord(id UrlId, longUrl string) error
-	Connected() bool
+	GetId(longUrl string) (UrlId, error)             // Zeroed out if not found
+	GetLongURL(id UrlId) (string, error)             // Empty string if not found
+	GetLink(id UrlId) (Link, error)                  // Zeroed out if not found
+	StoreLink(link Link) error                       // Clicks are ignored
+	StoreLinks(links []Link) error                   // All or none, with their clicks
+	RecordClick(id UrlId, click Click) (bool, error) // False if the link has no clicks left
+	ClickBreakdown(id UrlId) (ClickBreakdown, error)
+	UpdateLink(link Link, versions []LinkVersion) error             // Replaces URL and settings, recording versions alongside
+	MoveLink(from UrlId, to UrlId) error                            // Changes a link's id, with all that's stored for it
+	DeleteLink(id UrlId) (bool, error)                              // With all that's stored for it, false if there was no link
+	LinkHistory(id UrlId) ([]LinkVersion, error)                    // Oldest first, empty if never updated
+	ListLinks(filter LinkFilter) ([]Link, error)                    // In id order
+	StoreCampaign(campaign Campaign) error                          // Replaces any campaign with the same name
+	GetCampaign(name string) (Campaign, error)                      // Zeroed out if not found
+	ListCampaigns() ([]Campaign, error)                             // In name order
+	ForEachURLRecord(fn func(id UrlId, longUrl string) error) error // Stops at the first error from fn
+	ReserveIds(sequence string, count uint64) (uint64, error)       // The first of count ids taken, or the next if count is 0
+	Ping(ctx context.Context) error
 }
 
 type InMemoryUrlDbRecord struct {
@@ -20,10 +50,19 @@ type InMemoryUrlDbRecord struct {
 }
 
 type InMemoryUrlDb struct { // For use in testing, not robust at all
-	records []InMemoryUrlDbRecord
+	records   []InMemoryUrlDbRecord
+	settings  map[UrlId]LinkSettings
+	clicks    map[UrlId]uint64
+	events    map[UrlId][]Click
+	campaigns map[string]UTM
+	versions  map[UrlId][]LinkVersion
+	sequences map[string]uint64
+	lock      sync.RWMutex
 }
 
 func (imur *InMemoryUrlDb) GetId(longUrl string) (UrlId, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
 	for _, record := range imur.records {
 		if record.longUrl == longUrl {
 			return record.id, nil
@@ -33,6 +72,8 @@ func (imur *InMemoryUrlDb) GetId(longUrl string) (UrlId, error) {
 }
 
 func (imur *InMemoryUrlDb) GetLongURL(id UrlId) (string, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
 	for _, record := range imur.records {
 		if record.id == id {
 			return record.longUrl, nil
@@ -41,40 +82,321 @@ func (imur *InMemoryUrlDb) GetLongURL(id UrlId) (string, error) {
 	return "", nil
 }
 
+func (imur *InMemoryUrlDb) GetLink(id UrlId) (Link, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
+	for _, record := range imur.records {
+		if record.id == id {
+			return Link{Id: id, LongUrl: record.longUrl, Settings: imur.settings[id], Clicks: imur.clicks[id]}, nil
+		}
+	}
+	return Link{}, nil
+}
+
+// StoreURLRecord stores a link without settings
 func (imur *InMemoryUrlDb) StoreURLRecord(id UrlId, longUrl string) error {
-	imur.records = append(imur.records, InMemoryUrlDbRecord{id, longUrl})
+	return imur.StoreLink(Link{Id: id, LongUrl: longUrl})
+}
+
+func (imur *InMemoryUrlDb) StoreLink(link Link) error {
+	link.Clicks = 0
+	return imur.StoreLinks([]Link{link})
+}
+
+func (imur *InMemoryUrlDb) StoreLinks(links []Link) error {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	stored := make(map[string]bool, len(imur.records)+len(links))
+	ids := make(map[UrlId]bool, len(imur.records)+len(links))
+	for _, record := range imur.records {
+		stored[record.longUrl] = true
+		ids[record.id] = true
+	}
+	for _, link := range links {
+		if ids[link.Id] {
+			return ErrIdTaken // Mirrors the primary key, which MySQL checks first
+		}
+		if stored[link.LongUrl] {
+			return ErrDuplicateURL // Mirrors the UNIQUE constraint on urls.long_url
+		}
+		stored[link.LongUrl] = true
+		ids[link.Id] = true
+	}
+
+	for _, link := range links {
+		imur.records = append(imur.records, InMemoryUrlDbRecord{link.Id, link.LongUrl})
+		if !link.Settings.IsZero() {
+			if imur.settings == nil {
+				imur.settings = make(map[UrlId]LinkSettings)
+			}
+			imur.settings[link.Id] = link.Settings
+		}
+		if link.Clicks > 0 {
+			if imur.clicks == nil {
+				imur.clicks = make(map[UrlId]uint64)
+			}
+			imur.clicks[link.Id] = link.Clicks
+		}
+	}
 	return nil
 }
 
-func (imur *InMemoryUrlDb) Connected() bool {
-	return true
+func (imur *InMemoryUrlDb) RecordClick(id UrlId, click Click) (bool, error) {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	if max := imur.settings[id].MaxClicks; max > 0 && imur.clicks[id] >= max {
+		return false, nil
+	}
+	if imur.clicks == nil {
+		imur.clicks = make(map[UrlId]uint64)
+	}
+	imur.clicks[id]++
+	if click != (Click{}) {
+		if imur.events == nil {
+			imur.events = make(map[UrlId][]Click)
+		}
+		imur.events[id] = append(imur.events[id], click)
+	}
+	return true, nil
+}
+
+func (imur *InMemoryUrlDb) ClickBreakdown(id UrlId) (ClickBreakdown, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
+	breakdown := ClickBreakdown{Variants: make(map[string]uint64), Countries: make(map[string]uint64)}
+	for _, click := range imur.events[id] {
+		if click.Variant != "" {
+			breakdown.Variants[click.Variant]++
+		}
+		if click.Country != "" {
+			breakdown.Countries[click.Country]++
+		}
+	}
+	return breakdown, nil
+}
+
+func (imur *InMemoryUrlDb) UpdateLink(link Link, versions []LinkVersion) error {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	index := -1
+	for i, record := range imur.records {
+		if record.id == link.Id {
+			index = i
+		} else if record.longUrl == link.LongUrl {
+			return ErrDuplicateURL
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("no link %s to update", encodeBase62(link.Id))
+	}
+	existing := imur.versions[link.Id]
+	for _, v := range versions {
+		for _, e := range existing {
+			if e.Version == v.Version {
+				return ErrVersionConflict // Mirrors the primary key on link_versions
+			}
+		}
+	}
+
+	imur.records[index].longUrl = link.LongUrl
+	if imur.settings == nil {
+		imur.settings = make(map[UrlId]LinkSettings)
+	}
+	imur.settings[link.Id] = link.Settings
+	if imur.versions == nil {
+		imur.versions = make(map[UrlId][]LinkVersion)
+	}
+	imur.versions[link.Id] = append(existing, versions...)
+	return nil
+}
+
+func (imur *InMemoryUrlDb) MoveLink(from UrlId, to UrlId) error {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	index := -1
+	for i, record := range imur.records {
+		if record.id == to {
+			return ErrIdTaken
+		}
+		if record.id == from {
+			index = i
+		}
+	}
+	if index == -1 {
+		return fmt.Errorf("no link %s to move", encodeBase62(from))
+	}
+
+	imur.records[index].id = to
+	if settings, exists := imur.settings[from]; exists {
+		imur.settings[to] = settings
+		delete(imur.settings, from)
+	}
+	if clicks, exists := imur.clicks[from]; exists {
+		imur.clicks[to] = clicks
+		delete(imur.clicks, from)
+	}
+	if events, exists := imur.events[from]; exists {
+		imur.events[to] = events
+		delete(imur.events, from)
+	}
+	if versions, exists := imur.versions[from]; exists {
+		imur.versions[to] = versions
+		delete(imur.versions, from)
+	}
+	return nil
+}
+
+func (imur *InMemoryUrlDb) DeleteLink(id UrlId) (bool, error) {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	for i, record := range imur.records {
+		if record.id == id {
+			imur.records = append(imur.records[:i], imur.records[i+1:]...)
+			delete(imur.settings, id)
+			delete(imur.clicks, id)
+			delete(imur.events, id)
+			delete(imur.versions, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (imur *InMemoryUrlDb) LinkHistory(id UrlId) ([]LinkVersion, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
+	return append([]LinkVersion(nil), imur.versions[id]...), nil
+}
+
+func (imur *InMemoryUrlDb) ListLinks(filter LinkFilter) ([]Link, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
+	var links []Link
+	for _, record := range imur.records {
+		link := Link{Id: record.id, LongUrl: record.longUrl, Settings: imur.settings[record.id], Clicks: imur.clicks[record.id]}
+		if filter.matches(link) {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return bytes.Compare(links[i].Id[:], links[j].Id[:]) < 0 })
+	if len(links) > filter.Limit {
+		links = links[:filter.Limit]
+	}
+	return links, nil
+}
+
+func (imur *InMemoryUrlDb) StoreCampaign(campaign Campaign) error {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	if imur.campaigns == nil {
+		imur.campaigns = make(map[string]UTM)
+	}
+	imur.campaigns[campaign.Name] = campaign.UTM
+	return nil
+}
+
+func (imur *InMemoryUrlDb) GetCampaign(name string) (Campaign, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
+	utm, exists := imur.campaigns[name]
+	if !exists {
+		return Campaign{}, nil
+	}
+	return Campaign{Name: name, UTM: utm}, nil
+}
+
+func (imur *InMemoryUrlDb) ListCampaigns() ([]Campaign, error) {
+	imur.lock.RLock()
+	defer imur.lock.RUnlock()
+	campaigns := make([]Campaign, 0, len(imur.campaigns))
+	for name, utm := range imur.campaigns {
+		campaigns = append(campaigns, Campaign{Name: name, UTM: utm})
+	}
+	sort.Slice(campaigns, func(i, j int) bool { return campaigns[i].Name < campaigns[j].Name })
+	return campaigns, nil
+}
+
+func (imur *InMemoryUrlDb) ForEachURLRecord(fn func(id UrlId, longUrl string) error) error {
+	imur.lock.RLock()
+	records := append([]InMemoryUrlDbRecord(nil), imur.records...)
+	imur.lock.RUnlock()
+
+	for _, record := range records {
+		if err := fn(record.id, record.longUrl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imur *InMemoryUrlDb) ReserveIds(sequence string, count uint64) (uint64, error) {
+	imur.lock.Lock()
+	defer imur.lock.Unlock()
+	if imur.sequences == nil {
+		imur.sequences = make(map[string]uint64)
+	}
+	if _, exists := imur.sequences[sequence]; !exists {
+		imur.sequences[sequence] = firstCounter // As migration 0010 starts it
+	}
+	first := imur.sequences[sequence]
+	imur.sequences[sequence] += count
+	return first, nil
+}
+
+func (imur *InMemoryUrlDb) Ping(ctx context.Context) error {
+	return nil
 }
 
 type MySQLUrlDB struct {
-	db         *sql.DB
-	getIdStmt  *sql.Stmt
-	insertStmt *sql.Stmt
+	db          *sql.DB
+	migrations  *migrator
+	getIdStmt   *sql.Stmt
+	getLinkStmt *sql.Stmt
+	insertStmt  *sql.Stmt
+	clickStmt   *sql.Stmt
+	eventStmt   *sql.Stmt
 }
 
-func buildSQLRepo(driver string, dataSourceName string) (*MySQLUrlDB, func(), error) {
-	// Configure DB
+// openSQLDB opens and configures a connection pool, waiting for the database to come up
+func openSQLDB(driver string, dataSourceName string) (*sql.DB, error) {
 	db, err := sql.Open(driver, dataSourceName)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	sr := MySQLUrlDB{db: db}
-
 	db.SetConnMaxLifetime(time.Minute * 3)
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(10)
 
-	if !sr.Connected() {
-		return nil, nil, errors.New("Failed to connect to database")
+	if !waitForConnection(db) {
+		db.Close()
+		return nil, errors.New("Failed to connect to database")
+	}
+
+	return db, nil
+}
+
+func buildSQLRepo(driver string, dataSourceName string) (*MySQLUrlDB, func(), error) {
+	// Configure DB
+	db, err := openSQLDB(driver, dataSourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Refuse to run against a schema older than this binary expects
+	m, err := newMySQLMigrator(db, io.Discard)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	sr := MySQLUrlDB{db: db, migrations: m}
+	if err = sr.CheckSchema(context.Background()); err != nil {
+		db.Close()
+		return nil, nil, err
 	}
 
 	// Prepare statements
-	insertStmt, err := db.PrepareContext(context.Background(), "INSERT INTO urls (id, long_url) VALUES (?, ?)")
+	insertStmt, err := db.PrepareContext(context.Background(), "INSERT INTO urls (id, long_url, host, settings, clicks_remaining) VALUES (?, ?, ?, ?, ?)")
 	if err != nil {
 		return nil, nil, err
 	}
@@ -84,13 +406,36 @@ func buildSQLRepo(driver string, dataSourceName string) (*MySQLUrlDB, func(), er
 		return nil, nil, err
 	}
 
+	getLinkStmt, err := db.PrepareContext(context.Background(), "SELECT long_url, settings, clicks FROM urls WHERE id = ?")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// clicks_remaining is NULL for links without a limit, which the decrement leaves as it is
+	clickStmt, err := db.PrepareContext(context.Background(), `UPDATE urls SET clicks = clicks + 1, clicks_remaining = clicks_remaining - 1
+		WHERE id = ? AND (clicks_remaining IS NULL OR clicks_remaining > 0)`)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	eventStmt, err := db.PrepareContext(context.Background(), "INSERT INTO click_events (id, variant, country) VALUES (?, ?, ?)")
+	if err != nil {
+		return nil, nil, err
+	}
+
 	sr.insertStmt = insertStmt
 	sr.getIdStmt = getIdStmt
+	sr.getLinkStmt = getLinkStmt
+	sr.clickStmt = clickStmt
+	sr.eventStmt = eventStmt
 
 	// Create cleanup closure
 	dbTidy := func() {
 		insertStmt.Close()
 		getIdStmt.Close()
+		getLinkStmt.Close()
+		clickStmt.Close()
+		eventStmt.Close()
 		db.Close()
 	}
 
@@ -125,14 +470,515 @@ func (sr *MySQLUrlDB) GetLongURL(id UrlId) (string, error) {
 	return longUrl, nil // Successfully found
 }
 
+func (sr *MySQLUrlDB) GetLink(id UrlId) (Link, error) {
+	var rawSettings []byte
+	link := Link{Id: id}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err := sr.getLinkStmt.QueryRowContext(ctx, id[:]).Scan(&link.LongUrl, &rawSettings, &link.Clicks)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Link{}, nil // No link exists
+		}
+		return Link{}, err // An error occurred
+	}
+	if link.Settings, err = unmarshalSettings(rawSettings); err != nil {
+		return Link{}, fmt.Errorf("malformed settings for %s: %w", encodeBase62(id), err)
+	}
+	return link, nil // Successfully found
+}
+
+// StoreURLRecord stores a link without settings
 func (sr *MySQLUrlDB) StoreURLRecord(id UrlId, longUrl string) error {
+	return sr.StoreLink(Link{Id: id, LongUrl: longUrl})
+}
+
+func (sr *MySQLUrlDB) StoreLink(link Link) error {
+	rawSettings, err := marshalSettings(link.Settings)
+	if err != nil {
+		return err
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
-	_, err := sr.insertStmt.ExecContext(ctx, id[:], longUrl)
+	clicksRemaining := remainingClicks(link.Settings, 0)
+	if len(link.Settings.Tags) == 0 {
+		_, err = sr.insertStmt.ExecContext(ctx, link.Id[:], link.LongUrl, urlHost(link.LongUrl), rawSettings, clicksRemaining)
+		return duplicateKeyError(err)
+	}
+
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.StmtContext(ctx, sr.insertStmt).ExecContext(ctx, link.Id[:], link.LongUrl, urlHost(link.LongUrl), rawSettings, clicksRemaining); err != nil {
+		return duplicateKeyError(err)
+	}
+	if err = replaceTags(ctx, tx, link.Id, link.Settings.Tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StoreLinks inserts links with one statement per table in a transaction, which is far
+// quicker than a statement per link when importing or copying many
+func (sr *MySQLUrlDB) StoreLinks(links []Link) error {
+	if len(links) == 0 {
+		return nil
+	}
+	var rows, tagRows []string
+	var args, tagArgs []interface{}
+	for i := range links {
+		link := &links[i]
+		rawSettings, err := marshalSettings(link.Settings)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, "(?, ?, ?, ?, ?, ?)")
+		args = append(args, link.Id[:], link.LongUrl, urlHost(link.LongUrl), rawSettings, link.Clicks, remainingClicks(link.Settings, link.Clicks))
+		for _, tag := range link.Settings.Tags {
+			tagRows = append(tagRows, "(?, ?)")
+			tagArgs = append(tagArgs, tag, link.Id[:])
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "INSERT INTO urls (id, long_url, host, settings, clicks, clicks_remaining) VALUES "+strings.Join(rows, ", "), args...); err != nil {
+		return duplicateKeyError(err)
+	}
+	if len(tagRows) > 0 {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO link_tags (tag, id) VALUES "+strings.Join(tagRows, ", "), tagArgs...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// remainingClicks is what's left of a link's limit after clicks, NULL for links without one
+func remainingClicks(settings LinkSettings, clicks uint64) sql.NullInt64 {
+	if settings.MaxClicks == 0 {
+		return sql.NullInt64{}
+	}
+	var remaining int64
+	if settings.MaxClicks > clicks {
+		remaining = int64(settings.MaxClicks - clicks)
+	}
+	return sql.NullInt64{Int64: remaining, Valid: true}
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, for the default escape character
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// duplicateKeyError turns the error for a violated primary key on urls into ErrIdTaken,
+// and for the UNIQUE constraint on urls.long_url into ErrDuplicateURL. The message names
+// the key last, as 'PRIMARY' or, from MySQL 8, 'urls.PRIMARY'.
+func duplicateKeyError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlErrDupEntry {
+		return err
+	}
+	if strings.HasSuffix(mysqlErr.Message, "'PRIMARY'") || strings.HasSuffix(mysqlErr.Message, ".PRIMARY'") {
+		return fmt.Errorf("%w: %s", ErrIdTaken, mysqlErr.Message)
+	}
+	return fmt.Errorf("%w: %s", ErrDuplicateURL, mysqlErr.Message)
+}
+
+// replaceTags sets the tags of a link in link_tags, which indexes links by tag
+func replaceTags(ctx context.Context, tx *sql.Tx, id UrlId, tags []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_tags WHERE id = ?", id[:]); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO link_tags (tag, id) VALUES (?, ?)", tag, id[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordClick counts a click with a single conditional update, so that concurrent
+// requests on any number of instances can't take a link past its limit. Clicks with a
+// variant or country are also logged as an event, outside of that update, so a failure
+// there only loses the attribution.
+func (sr *MySQLUrlDB) RecordClick(id UrlId, click Click) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	res, err := sr.clickStmt.ExecContext(ctx, id[:])
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 1 && click != (Click{}) {
+		if _, err = sr.eventStmt.ExecContext(ctx, id[:], nullString(click.Variant), nullString(click.Country)); err != nil {
+			return true, err
+		}
+	}
+	return affected == 1, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func (sr *MySQLUrlDB) ClickBreakdown(id UrlId) (ClickBreakdown, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	variants, err := sr.countClicksBy(ctx, id, "variant")
+	if err != nil {
+		return ClickBreakdown{}, err
+	}
+	countries, err := sr.countClicksBy(ctx, id, "country")
+	if err != nil {
+		return ClickBreakdown{}, err
+	}
+	return ClickBreakdown{Variants: variants, Countries: countries}, nil
+}
+
+// countClicksBy counts the click events of a link grouped by column, which must not
+// come from user input
+func (sr *MySQLUrlDB) countClicksBy(ctx context.Context, id UrlId, column string) (map[string]uint64, error) {
+	query := fmt.Sprintf("SELECT %[1]s, COUNT(*) FROM click_events WHERE id = ? AND %[1]s IS NOT NULL GROUP BY %[1]s", column)
+	rows, err := sr.db.QueryContext(ctx, query, id[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]uint64)
+	for rows.Next() {
+		var value string
+		var n uint64
+		if err = rows.Scan(&value, &n); err != nil {
+			return nil, err
+		}
+		counts[value] = n
+	}
+	return counts, rows.Err()
+}
+
+// UpdateLink changes the link and records its versions in one transaction, so that the
+// history always matches the link. The primary key on link_versions turns concurrent
+// updates of a link into ErrVersionConflict for all but the first.
+func (sr *MySQLUrlDB) UpdateLink(link Link, versions []LinkVersion) error {
+	rawSettings, err := marshalSettings(link.Settings)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locked rather than checking the rows the update affects, which MySQL doesn't count
+	// if nothing changes, so that a link deleted meanwhile isn't given versions
+	var exists int
+	err = tx.QueryRowContext(ctx, "SELECT 1 FROM urls WHERE id = ? FOR UPDATE", link.Id[:]).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no link %s to update", encodeBase62(link.Id))
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		rawVersionSettings, err := marshalSettings(v.Settings)
+		if err != nil {
+			return err
+		}
+		rawDiff, err := json.Marshal(v.Diff)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO link_versions (id, version, actor, changed_at, long_url, settings, diff)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, link.Id[:], v.Version, v.Actor, v.ChangedAt, v.LongUrl, rawVersionSettings, rawDiff)
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDupEntry {
+			return ErrVersionConflict
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// clicks_remaining follows a changed limit, counting the clicks already made
+	maxClicks := link.Settings.MaxClicks
+	_, err = tx.ExecContext(ctx, `UPDATE urls SET long_url = ?, host = ?, settings = ?,
+		clicks_remaining = IF(? = 0, NULL, IF(? > clicks, ? - clicks, 0)) WHERE id = ?`,
+		link.LongUrl, urlHost(link.LongUrl), rawSettings, maxClicks, maxClicks, maxClicks, link.Id[:])
+	if err != nil {
+		return duplicateKeyError(err)
+	}
+	if err = replaceTags(ctx, tx, link.Id, link.Settings.Tags); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveLink changes the id of a link's row and of every row that refers to it, in one
+// transaction
+func (sr *MySQLUrlDB) MoveLink(from UrlId, to UrlId) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE urls SET id = ? WHERE id = ?", to[:], from[:])
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrDupEntry {
+		return ErrIdTaken
+	}
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		if err == nil {
+			err = fmt.Errorf("no link %s to move", encodeBase62(from))
+		}
+		return err
+	}
+	for _, table := range []string{"link_tags", "link_versions", "click_events"} {
+		if _, err = tx.ExecContext(ctx, "UPDATE "+table+" SET id = ? WHERE id = ?", to[:], from[:]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (sr *MySQLUrlDB) DeleteLink(id UrlId) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM urls WHERE id = ?", id[:])
+	if err != nil {
+		return false, err
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	for _, table := range []string{"link_tags", "link_versions", "click_events"} {
+		if _, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE id = ?", id[:]); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+func (sr *MySQLUrlDB) LinkHistory(id UrlId) ([]LinkVersion, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	rows, err := sr.db.QueryContext(ctx, `SELECT version, actor, changed_at, long_url, settings, diff
+		FROM link_versions WHERE id = ? ORDER BY version`, id[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []LinkVersion
+	for rows.Next() {
+		var v LinkVersion
+		var changedAt mysql.NullTime // Parses the timestamp even without parseTime in the DSN
+		var rawSettings, rawDiff []byte
+		if err = rows.Scan(&v.Version, &v.Actor, &changedAt, &v.LongUrl, &rawSettings, &rawDiff); err != nil {
+			return nil, err
+		}
+		v.ChangedAt = changedAt.Time
+		if v.Settings, err = unmarshalSettings(rawSettings); err != nil {
+			return nil, fmt.Errorf("malformed settings in version %d of %s: %w", v.Version, encodeBase62(id), err)
+		}
+		if err = json.Unmarshal(rawDiff, &v.Diff); err != nil {
+			return nil, fmt.Errorf("malformed diff in version %d of %s: %w", v.Version, encodeBase62(id), err)
+		}
+		history = append(history, v)
+	}
+	return history, rows.Err()
+}
+
+// ListLinks reads a range of the primary key, of link_tags when filtering by tag and
+// of urls otherwise. Host search can't use an index, but only scans within that range.
+func (sr *MySQLUrlDB) ListLinks(filter LinkFilter) ([]Link, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	from := "urls u"
+	idColumn := "u.id"
+	var conditions []string
+	var args []interface{}
+	if filter.Tag != "" {
+		from = "link_tags t JOIN urls u ON u.id = t.id"
+		idColumn = "t.id"
+		conditions = append(conditions, "t.tag = ?")
+		args = append(args, filter.Tag)
+	}
+	conditions = append(conditions, idColumn+" > ?", idColumn+" >= ?")
+	args = append(args, filter.After[:], filter.From[:])
+	if filter.To != (UrlId{}) {
+		conditions = append(conditions, idColumn+" < ?")
+		args = append(args, filter.To[:])
+	}
+	if filter.Host != "" {
+		conditions = append(conditions, "u.host LIKE ?")
+		args = append(args, "%"+likeEscaper.Replace(filter.Host)+"%")
+	}
+	query := fmt.Sprintf("SELECT u.id, u.long_url, u.settings, u.clicks FROM %s WHERE %s ORDER BY %s LIMIT ?",
+		from, strings.Join(conditions, " AND "), idColumn)
+	args = append(args, filter.Limit)
+
+	rows, err := sr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []Link
+	for rows.Next() {
+		var link Link
+		var idSlice, rawSettings []byte
+		if err = rows.Scan(&idSlice, &link.LongUrl, &rawSettings, &link.Clicks); err != nil {
+			return nil, err
+		}
+		copy(link.Id[:], idSlice)
+		if link.Settings, err = unmarshalSettings(rawSettings); err != nil {
+			return nil, fmt.Errorf("malformed settings for %s: %w", encodeBase62(link.Id), err)
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (sr *MySQLUrlDB) StoreCampaign(campaign Campaign) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	utm := campaign.UTM
+	_, err := sr.db.ExecContext(ctx, `INSERT INTO campaigns (name, utm_source, utm_medium, utm_campaign, utm_term, utm_content)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE utm_source = VALUES(utm_source), utm_medium = VALUES(utm_medium),
+			utm_campaign = VALUES(utm_campaign), utm_term = VALUES(utm_term), utm_content = VALUES(utm_content)`,
+		campaign.Name, utm.Source, utm.Medium, utm.Campaign, utm.Term, utm.Content)
 	return err
 }
 
-func (sr *MySQLUrlDB) Connected() bool {
+func (sr *MySQLUrlDB) GetCampaign(name string) (Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	campaign := Campaign{Name: name}
+	utm := &campaign.UTM
+	err := sr.db.QueryRowContext(ctx, "SELECT utm_source, utm_medium, utm_campaign, utm_term, utm_content FROM campaigns WHERE name = ?", name).
+		Scan(&utm.Source, &utm.Medium, &utm.Campaign, &utm.Term, &utm.Content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Campaign{}, nil // No campaign exists
+	}
+	if err != nil {
+		return Campaign{}, err
+	}
+	return campaign, nil
+}
+
+func (sr *MySQLUrlDB) ListCampaigns() ([]Campaign, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	rows, err := sr.db.QueryContext(ctx, "SELECT name, utm_source, utm_medium, utm_campaign, utm_term, utm_content FROM campaigns ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []Campaign
+	for rows.Next() {
+		var campaign Campaign
+		utm := &campaign.UTM
+		if err = rows.Scan(&campaign.Name, &utm.Source, &utm.Medium, &utm.Campaign, &utm.Term, &utm.Content); err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, campaign)
+	}
+	return campaigns, rows.Err()
+}
+
+func (sr *MySQLUrlDB) ForEachURLRecord(fn func(id UrlId, longUrl string) error) error {
+	rows, err := sr.db.QueryContext(context.Background(), "SELECT id, long_url FROM urls ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var idSlice []byte
+		var id UrlId
+		var longUrl string
+		if err := rows.Scan(&idSlice, &longUrl); err != nil {
+			return err
+		}
+		copy(id[:], idSlice)
+		if err := fn(id, longUrl); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ReserveIds takes count ids with a single update, so instances sharing the database
+// can't be handed the same ones. LAST_INSERT_ID is given the new value of the sequence,
+// which the driver reports back without another query.
+func (sr *MySQLUrlDB) ReserveIds(sequence string, count uint64) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if count == 0 {
+		var next uint64
+		err := sr.db.QueryRowContext(ctx, "SELECT next_id FROM id_sequences WHERE name = ?", sequence).Scan(&next)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("no sequence %q", sequence)
+		}
+		return next, err
+	}
+	res, err := sr.db.ExecContext(ctx, "UPDATE id_sequences SET next_id = LAST_INSERT_ID(next_id + ?) WHERE name = ?", count, sequence)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, fmt.Errorf("no sequence %q", sequence)
+	}
+	next, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(next) - count, nil
+}
+
+func (sr *MySQLUrlDB) Ping(ctx context.Context) error {
+	return sr.db.PingContext(ctx)
+}
+
+// CheckSchema returns an error if the database is missing migrations this binary needs
+func (sr *MySQLUrlDB) CheckSchema(ctx context.Context) error {
+	return sr.migrations.checkCurrent(ctx)
+}
+
+// waitForConnection pings db with exponential backoff, for use while starting up
+func waitForConnection(db *sql.DB) bool {
 	timeout := 6250 * time.Microsecond // Expands to ~30s with 10 attempts
 	attempts := 0
 	limit := 10
@@ -142,7 +988,7 @@ func (sr *MySQLUrlDB) Connected() bool {
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		err := sr.db.PingContext(ctx)
+		err := db.PingContext(ctx)
 
 		// Connected successfully
 		if err == nil {
@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"testing"
+)
 
 func TestInMemoryURLRepo_StoreURLRecord(t *testing.T) {
 	imur := InMemoryUrlDb{records: []InMemoryUrlDbRecord{}}
@@ -88,3 +92,61 @@ func TestInMemoryURLRepo_GetLongURL_MultipleRecords(t *testing.T) {
 		t.Error("GetLongURL should return the correct long URL")
 	}
 }
+
+func TestInMemoryURLRepo_StoreURLRecord_Duplicate(t *testing.T) {
+	imur := InMemoryUrlDb{records: []InMemoryUrlDbRecord{{UrlId{1, 2, 3, 4, 5}, "long"}}}
+	err := imur.StoreURLRecord(UrlId{5, 4, 3, 2, 1}, "long")
+	if !errors.Is(err, ErrDuplicateURL) {
+		t.Error("StoreURLRecord should reject a long URL that is already stored")
+	}
+}
+
+func TestInMemoryURLRepo_StoreLink_GetLink(t *testing.T) {
+	imur := InMemoryUrlDb{}
+	id := UrlId{1, 2, 3, 4, 5}
+	err := imur.StoreLink(Link{Id: id, LongUrl: "long", Settings: LinkSettings{Interstitial: true}})
+	if err != nil {
+		t.Error("StoreLink should not return an error")
+	}
+	if _, err = imur.RecordClick(id, Click{}); err != nil {
+		t.Error("RecordClick should not return an error")
+	}
+	link, err := imur.GetLink(id)
+	if err != nil {
+		t.Error("GetLink should not return an error")
+	}
+	if link.LongUrl != "long" || !link.Settings.Interstitial || link.Clicks != 1 {
+		t.Error("GetLink should return the stored link with its clicks")
+	}
+}
+
+func TestInMemoryURLRepo_GetLink_NotFound(t *testing.T) {
+	imur := InMemoryUrlDb{}
+	link, err := imur.GetLink(UrlId{1, 2, 3, 4, 5})
+	if err != nil {
+		t.Error("GetLink should not return an error")
+	}
+	if link.Exists() {
+		t.Error("GetLink should return a zeroed out link when not found")
+	}
+}
+
+func TestInMemoryURLRepo_StoreURLRecord_IdTaken(t *testing.T) {
+	imur := InMemoryUrlDb{records: []InMemoryUrlDbRecord{{UrlId{1, 2, 3, 4, 5}, "long"}}}
+	if err := imur.StoreURLRecord(UrlId{1, 2, 3, 4, 5}, "other"); !errors.Is(err, ErrIdTaken) {
+		t.Errorf("Expected ErrIdTaken, got %v", err)
+	}
+}
+
+func TestDuplicateKeyError(t *testing.T) {
+	for message, expected := range map[string]error{
+		"Duplicate entry 'x' for key 'PRIMARY'":       ErrIdTaken,
+		"Duplicate entry 'x' for key 'urls.PRIMARY'":  ErrIdTaken,
+		"Duplicate entry 'x' for key 'long_url'":      ErrDuplicateURL,
+		"Duplicate entry 'x' for key 'urls.long_url'": ErrDuplicateURL,
+	} {
+		if err := duplicateKeyError(&mysql.MySQLError{Number: mysqlErrDupEntry, Message: message}); !errors.Is(err, expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, message, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// previewSuffix appended to a short URL asks for its preview page instead of a redirect
+const previewSuffix = "+"
+
+// previewPath appended to a short URL does the same as previewSuffix
+const previewPath = "/preview"
+
+// handleFollow sends the browser on to the long URL, for visitors of publicURL. It also
+// serves the paths below publicURL, which are passed on to the destination of links
+// that allow it.
+func (s *server) handleFollow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shortUrl := c.Param("code")
+		extraPath := strings.TrimPrefix(c.Param("path"), "/")
+		if strings.HasSuffix(shortUrl, previewSuffix) && extraPath == "" {
+			s.preview(c, strings.TrimSuffix(shortUrl, previewSuffix))
+			return
+		}
+		if "/"+extraPath == previewPath {
+			s.preview(c, shortUrl)
+			return
+		}
+
+		link, ok := s.findLink(c, shortUrl)
+		if !ok {
+			return
+		}
+		if extraPath != "" && !link.Settings.Passthrough {
+			renderPage(c, http.StatusNotFound, "not-found", nil)
+			return
+		}
+
+		if !s.available(c, link) { // Don't ask for the password of a link that won't open
+			return
+		}
+
+		if link.Settings.PasswordHash != "" && !s.hasUnlockCookie(c, link) {
+			renderPage(c, http.StatusUnauthorized, "password", passwordPage{PublicURL: s.publicURL(shortUrl), Action: c.Request.URL.RequestURI()})
+			return
+		}
+
+		s.deliver(c, link, http.StatusFound)
+	}
+}
+
+// available reports whether link can be followed now, and if not, serves the page that
+// explains why or the link's pending URL
+func (s *server) available(c *gin.Context, link Link) bool {
+	switch link.window(time.Now()) {
+	case windowPending:
+		if link.Settings.PendingURL != "" {
+			c.Redirect(http.StatusFound, link.Settings.PendingURL)
+		} else {
+			renderPage(c, http.StatusNotFound, "pending", pendingPage{NotBefore: *link.Settings.NotBefore})
+		}
+		return false
+	case windowClosed:
+		renderPage(c, http.StatusGone, "gone", nil)
+		return false
+	}
+	if link.Exhausted() {
+		renderPage(c, http.StatusGone, "gone", nil)
+		return false
+	}
+	return true
+}
+
+// deliver counts the visit and sends the visitor on, or shows the interstitial
+func (s *server) deliver(c *gin.Context, link Link, status int) {
+	longUrl, click := s.destination(c, link)
+	longUrl, err := tagDestination(longUrl, link.Settings.UTM)
+	if err == nil && link.Settings.Passthrough { // After tagging, so that visitors can't replace the tags
+		longUrl, err = passThrough(longUrl, c.Param("path"), c.Request.URL.Query())
+	}
+	if err != nil {
+		_ = c.Error(err)
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	counted, err := s.app.recordClick(link, click)
+	if err != nil {
+		_ = c.Error(err)
+		if !counted && link.Settings.MaxClicks > 0 { // Fail closed rather than risk exceeding the limit
+			c.String(http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		counted = true // Otherwise losing a click isn't worth failing the redirect over
+	}
+	if !counted {
+		renderPage(c, http.StatusGone, "gone", nil)
+		return
+	}
+
+	if link.Settings.Interstitial {
+		page := s.previewPage(c, link, true)
+		page.LongUrl = longUrl
+		renderPage(c, http.StatusOK, "preview", page)
+		return
+	}
+
+	c.Redirect(status, longUrl)
+}
+
+// preview shows where a link leads, or outside its window the page following it would
+func (s *server) preview(c *gin.Context, shortUrl string) {
+	link, ok := s.findLink(c, shortUrl)
+	if !ok || !s.available(c, link) {
+		return
+	}
+	renderPage(c, http.StatusOK, "preview", s.previewPage(c, link, false))
+}
+
+// previewPage describes link, withholding the destination of a protected link from
+// visitors who haven't entered its password
+func (s *server) previewPage(c *gin.Context, link Link, interstitial bool) previewPage {
+	page := previewPage{
+		PublicURL:    s.publicURL(s.app.shortCode(link.Id)),
+		LongUrl:      link.LongUrl,
+		CreatedAt:    link.CreatedAt(),
+		Clicks:       link.Clicks,
+		Interstitial: interstitial,
+	}
+	if link.Settings.PasswordHash != "" && !s.hasUnlockCookie(c, link) {
+		page.LongUrl = ""
+		page.Protected = true
+	} else if link.Limited() && !interstitial { // The interstitial is shown once a click is counted
+		page.LongUrl = ""
+		page.Limited = true
+	}
+	return page
+}
+
+// cookiePath limits a cookie for a link to the short URL it was visited by, which with
+// several codecs might not be the one it's encoded as now
+func cookiePath(c *gin.Context) string {
+	return "/" + strings.TrimSuffix(c.Param("code"), previewSuffix)
+}
+
+// findLink looks up shortUrl, rendering an error page and returning false if that fails
+func (s *server) findLink(c *gin.Context, shortUrl string) (Link, bool) {
+	link, err := s.app.lookup(shortUrl)
+	if err != nil {
+		_ = c.Error(err)
+		c.String(http.StatusInternalServerError, "Internal server error")
+		return Link{}, false
+	}
+	if !link.Exists() {
+		renderPage(c, http.StatusNotFound, "not-found", nil)
+		return Link{}, false
+	}
+	return link, true
+}
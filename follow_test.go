@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_Follow_CountsClicks(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://www.google.com")
+
+	doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+
+	link, err := s.app.lookup(shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Clicks != 2 {
+		t.Errorf("Expected 2 clicks, got %d", link.Clicks)
+	}
+}
+
+func TestServer_Preview(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://www.google.com")
+	created := Link{Id: decodeBase62(shortUrl)}.CreatedAt().Format("January 2, 2006")
+
+	for _, target := range []string{"/" + shortUrl + "+", "/" + shortUrl + "/preview"} {
+		rec := doRequest(s, http.MethodGet, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d", target, rec.Code)
+		}
+		body := rec.Body.String()
+		if !strings.Contains(body, "https://www.google.com") || !strings.Contains(body, created) {
+			t.Errorf("Preview at %s should show the destination and creation date", target)
+		}
+	}
+
+	link, _ := s.app.lookup(shortUrl)
+	if link.Clicks != 0 {
+		t.Error("Previews should not count as clicks")
+	}
+}
+
+func TestServer_Preview_UnknownCode(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodGet, "/"+encodeBase62(UrlId{1, 2, 3})+"+", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func TestServer_Follow_Interstitial(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://www.google.com", "interstitial": true}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the interstitial page, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `href="https://www.google.com"`) {
+		t.Error("The interstitial should link on to the destination")
+	}
+
+	link, _ := s.app.lookup(shortUrl)
+	if link.Clicks != 1 {
+		t.Error("Showing the interstitial should count as a click")
+	}
+}
+
+func TestServer_Follow_OneTime(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://www.google.com", "maxClicks": 1}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	if rec := doRequest(s, http.MethodGet, "/"+shortUrl, nil); rec.Code != http.StatusFound {
+		t.Fatalf("Expected 302 on the first visit, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodGet, "/"+shortUrl, nil); rec.Code != http.StatusGone {
+		t.Errorf("Expected 410 once the link is used up, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+shortUrl, nil); rec.Code != http.StatusGone {
+		t.Errorf("Expected 410 from the API once the link is used up, got %d", rec.Code)
+	}
+}
+
+func TestServer_Follow_OneTime_DestinationWithheld(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://www.google.com", "maxClicks": 1}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	if rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+shortUrl, nil); rec.Code != http.StatusForbidden || strings.Contains(rec.Body.String(), "google") {
+		t.Errorf("Expected 403 without the destination from the API, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(s, http.MethodGet, "/"+shortUrl+"+", nil); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "www.google.com") {
+		t.Errorf("Expected a preview without the destination, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodGet, "/"+shortUrl, nil); rec.Code != http.StatusFound {
+		t.Errorf("Expected the click to be left for following the link, got %d", rec.Code)
+	}
+}
+
+func TestServer_Follow_MaxClicksConcurrent(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://www.google.com", "maxClicks": 3}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	var redirects int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if doRequest(s, http.MethodGet, "/"+shortUrl, nil).Code == http.StatusFound {
+				atomic.AddInt32(&redirects, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if redirects != 3 {
+		t.Errorf("Expected exactly 3 redirects, got %d", redirects)
+	}
+}
+
+func shortenWindowForTest(t *testing.T, s *server, longUrl string, window string) string {
+	t.Helper()
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "`+longUrl+`", `+window+`}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return decodeBody(t, rec)["shortUrl"]
+}
+
+func TestServer_Follow_ActivationWindow(t *testing.T) {
+	s := newTestServer(t)
+	hour := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	hourAgo := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	pending := shortenWindowForTest(t, s, "https://example.com/launch", `"notBefore": "`+hour+`"`)
+	rec := doRequest(s, http.MethodGet, "/"+pending, nil)
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "not yet available") {
+		t.Errorf("Expected the not yet available page, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+pending, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 from the API before activation, got %d", rec.Code)
+	}
+
+	fallback := shortenWindowForTest(t, s, "https://example.com/launch2", `"notBefore": "`+hour+`", "pendingUrl": "https://example.com/soon"`)
+	rec = doRequest(s, http.MethodGet, "/"+fallback, nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/soon" {
+		t.Errorf("Expected a redirect to the pending URL, got %d to %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	ended := shortenWindowForTest(t, s, "https://example.com/sale", `"notAfter": "`+hourAgo+`"`)
+	if rec := doRequest(s, http.MethodGet, "/"+ended, nil); rec.Code != http.StatusGone {
+		t.Errorf("Expected 410 after the window, got %d", rec.Code)
+	}
+
+	open := shortenWindowForTest(t, s, "https://example.com/now", `"notBefore": "`+hourAgo+`", "notAfter": "`+hour+`"`)
+	if rec := doRequest(s, http.MethodGet, "/"+open, nil); rec.Code != http.StatusFound {
+		t.Errorf("Expected a redirect inside the window, got %d", rec.Code)
+	}
+
+	for _, preview := range []string{"/" + pending + previewSuffix, "/" + pending + previewPath, "/" + ended + previewSuffix} {
+		rec := doRequest(s, http.MethodGet, preview, nil)
+		if rec.Code == http.StatusOK || strings.Contains(rec.Body.String(), "example.com") {
+			t.Errorf("Expected %s to withhold the destination outside the window, got %d: %s", preview, rec.Code, rec.Body.String())
+		}
+	}
+
+	link, _ := s.app.lookup(pending)
+	if link.Clicks != 0 {
+		t.Error("Visits before activation should not count as clicks")
+	}
+}
+
+func TestServer_Shorten_InvalidWindow(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "notBefore": "2030-01-02T00:00:00Z", "notAfter": "2030-01-01T00:00:00Z"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a window that ends before it starts, got %d", rec.Code)
+	}
+}
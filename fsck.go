@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Kinds of problem fsck finds
+const (
+	issueRoundTrip  = "round-trip"
+	issuePaddingBit = "padding-bit"
+	issueFuture     = "future-timestamp"
+	issueDuplicate  = "duplicate-url"
+	issueInvalidURL = "invalid-url"
+)
+
+// fsckIssue is a problem with one stored link
+type fsckIssue struct {
+	id       UrlId
+	code     string // The short URL of id
+	kind     string
+	detail   string
+	repaired bool
+}
+
+func (i fsckIssue) String() string {
+	s := fmt.Sprintf("%s %s: %s", i.code, i.kind, i.detail)
+	if i.repaired {
+		s += " (repaired)"
+	}
+	return s
+}
+
+type fsckReport struct {
+	checked  int
+	issues   int
+	repaired int
+}
+
+// fsck checks every stored link for problems that the code storing them should have
+// prevented. With repair set, links whose id has the padding bit set are moved to the id
+// their short URL decodes to, which is the only fix that doesn't change a short URL
+// already handed out. Everything else is left for a person to decide on.
+type fsck struct {
+	db      UrlDB
+	codecs  codecChain // What short URLs are reported as
+	now     time.Time
+	maxSkew time.Duration // How far ahead of now an id's timestamp may be, for clock skew
+	repair  bool
+	onIssue func(issue fsckIssue)
+}
+
+func newFsck(db UrlDB) *fsck {
+	return &fsck{
+		db:      db,
+		codecs:  defaultCodecs,
+		now:     time.Now(),
+		maxSkew: time.Minute,
+		onIssue: func(fsckIssue) {},
+	}
+}
+
+// run reads ids and long URLs only, so that a link with malformed settings can't stop
+// the scan. Canonical long URLs are remembered to find duplicates, which takes memory
+// in proportion to the number of links.
+func (f *fsck) run() (fsckReport, error) {
+	var report fsckReport
+	var padded []UrlId
+	canonical := make(map[string]UrlId)
+	found := func(issue fsckIssue) {
+		issue.code = f.codecs.encode(issue.id)
+		report.issues++
+		f.onIssue(issue)
+	}
+	err := f.db.ForEachURLRecord(func(id UrlId, longUrl string) error {
+		report.checked++
+		shortUrl := encodeBase62(id)
+		if id[4]&0x80 != 0 { // Dropped by encoding, so the id doesn't round trip either
+			padded = append(padded, id)
+		} else if decoded := decodeBase62(shortUrl); decoded != id {
+			found(fsckIssue{id: id, kind: issueRoundTrip, detail: fmt.Sprintf("%s leads to %x instead of %x", shortUrl, decoded[:], id[:])})
+		}
+		if seconds, _ := decodeID(id); time.Unix(int64(seconds), 0).After(f.now.Add(f.maxSkew)) {
+			found(fsckIssue{id: id, kind: issueFuture, detail: "created " + time.Unix(int64(seconds), 0).UTC().Format(time.RFC3339)})
+		}
+
+		canonicalUrl, err := canonicalURL(longUrl)
+		if err != nil {
+			found(fsckIssue{id: id, kind: issueInvalidURL, detail: err.Error()})
+			return nil
+		}
+		if first, exists := canonical[canonicalUrl]; exists {
+			found(fsckIssue{id: id, kind: issueDuplicate, detail: "same URL as " + f.codecs.encode(first)})
+		} else {
+			canonical[canonicalUrl] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// Moved after the scan, rather than while reading, so no link is visited twice
+	for _, id := range padded {
+		issue := fsckIssue{id: id, kind: issuePaddingBit, detail: "its short URL leads to another id"}
+		if f.repair {
+			if err = f.moveToDecodedId(id); errors.Is(err, ErrIdTaken) {
+				issue.detail += ", which is taken"
+			} else if err != nil {
+				return report, err
+			} else {
+				issue.repaired = true
+				report.repaired++
+			}
+		}
+		found(issue)
+	}
+	return report, nil
+}
+
+// moveToDecodedId moves a link to the id its short URL decodes to, so that it works
+func (f *fsck) moveToDecodedId(id UrlId) error {
+	return f.db.MoveLink(id, decodeBase62(encodeBase62(id)))
+}
+
+// canonicalURL returns longUrl in a form that's the same for URLs which lead to the same
+// place: with the scheme and host lower cased, without the default port or a fragment,
+// and with a path of at least /. Long URLs without a scheme are taken to be http, as
+// browsers do. It returns an error for a URL that can't be followed.
+func canonicalURL(longUrl string) (string, error) {
+	if longUrl == "" {
+		return "", errors.New("empty")
+	}
+	if strings.IndexFunc(longUrl, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) != -1 {
+		return "", errors.New("contains whitespace or control characters")
+	}
+	if !strings.Contains(longUrl, "://") && !strings.Contains(strings.SplitN(longUrl, "/", 2)[0], ":") {
+		longUrl = "http://" + longUrl
+	}
+	u, err := url.Parse(longUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("scheme %q can't be redirected to", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", errors.New("no host")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") { // IPv6
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Fragment, u.RawFragment = "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	tests := map[string]string{
+		"www.google.com":                  "http://www.google.com/",
+		"HTTPS://Example.COM:443/a?b=1#c": "https://example.com/a?b=1",
+		"http://example.com:8080":         "http://example.com:8080/",
+		"http://[::1]:80/x":               "http://[::1]/x",
+	}
+	for longUrl, want := range tests {
+		if got, err := canonicalURL(longUrl); err != nil || got != want {
+			t.Errorf("canonicalURL(%q) = %q, %v, want %q", longUrl, got, err, want)
+		}
+	}
+
+	for _, longUrl := range []string{"", "javascript:alert(1)", "https://", "http://exa mple.com", "ftp://example.com/file"} {
+		if _, err := canonicalURL(longUrl); err == nil {
+			t.Errorf("Expected %q to be invalid", longUrl)
+		}
+	}
+}
+
+func TestFsck(t *testing.T) {
+	now := time.Date(2024, 4, 25, 0, 0, 0, 0, time.UTC)
+	at := func(seconds time.Time, seq uint32) UrlId {
+		id := UrlId{}
+		_ = encodeID(&id, uint32(seconds.Unix()), seq)
+		return id
+	}
+	padded := at(now, 1)
+	padded[4] |= 0x80
+	db := &InMemoryUrlDb{records: []InMemoryUrlDbRecord{
+		{at(now, 0), "https://example.com/"},
+		{padded, "https://example.com/padded"},
+		{at(now.Add(time.Hour), 0), "https://example.com/future"},
+		{at(now, 2), "HTTPS://EXAMPLE.COM"},
+		{at(now, 3), "javascript:alert(1)"},
+	}}
+
+	var issues []string
+	f := newFsck(db)
+	f.now = now
+	f.onIssue = func(issue fsckIssue) {
+		issues = append(issues, issue.kind)
+	}
+	report, err := f.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{issueFuture, issueDuplicate, issueInvalidURL, issuePaddingBit}
+	if strings.Join(issues, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected issues %v, got %v", want, issues)
+	}
+	if report.checked != 5 || report.issues != 4 || report.repaired != 0 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+
+	f.repair = true
+	issues = nil
+	if report, err = f.run(); err != nil {
+		t.Fatal(err)
+	}
+	if report.repaired != 1 {
+		t.Errorf("Expected the padded id to be repaired, got %+v", report)
+	}
+	if longUrl, _ := db.GetLongURL(decodeBase62(encodeBase62(padded))); longUrl != "https://example.com/padded" {
+		t.Error("The repaired link should be found by its short URL")
+	}
+}
+
+func TestCLI_Fsck(t *testing.T) {
+	c, stdout, _ := newTestCLI(&InMemoryUrlDb{records: []InMemoryUrlDbRecord{{UrlId{1, 2, 3, 4, 5}, "data:text/html,hi"}}}, "")
+	if code := c.run([]string{"fsck"}); code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if !strings.Contains(stdout.String(), issueInvalidURL) {
+		t.Errorf("Expected the invalid URL to be reported, got %q", stdout.String())
+	}
+
+	c, _, _ = newTestCLI(&InMemoryUrlDb{records: []InMemoryUrlDbRecord{{UrlId{1, 2, 3, 4, 5}, "https://example.com"}}}, "")
+	if code := c.run([]string{"fsck"}); code != exitOK {
+		t.Errorf("Expected exit code %d for a clean store, got %d", exitOK, code)
+	}
+}
+
+func TestCLI_Fsck_Codec(t *testing.T) {
+	c, stdout, _ := newTestCLI(&InMemoryUrlDb{records: []InMemoryUrlDbRecord{
+		{counterId(firstCounter), "https://example.com"},
+		{counterId(firstCounter + 1), "https://EXAMPLE.com/"},
+	}}, "")
+	c.cfg.codecs = codecChain{compactCodec{}}
+	if code := c.run([]string{"fsck"}); code != exitFailure {
+		t.Errorf("Expected exit code %d, got %d", exitFailure, code)
+	}
+	if want := "1001 " + issueDuplicate + ": same URL as 1000\n"; stdout.String() != want {
+		t.Errorf("Expected links to be reported by their compact codes, got %q", stdout.String())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// location is where a visitor's IP address places them, empty where unknown
+type location struct {
+	country string // ISO 3166-1, e.g. "US"
+	region  string // ISO 3166-2, e.g. "US-CA"
+}
+
+type locationReader interface {
+	locate(ip net.IP) (location, error)
+	Close() error
+}
+
+// mmdbReader reads a MaxMind format database, such as GeoLite2 Country or City
+type mmdbReader struct {
+	db *maxminddb.Reader
+}
+
+func openMMDB(path string) (locationReader, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &mmdbReader{db: db}, nil
+}
+
+func (mr *mmdbReader) locate(ip net.IP) (location, error) {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		Subdivisions []struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"subdivisions"`
+	}
+	if err := mr.db.Lookup(ip, &record); err != nil {
+		return location{}, err
+	}
+	loc := location{country: record.Country.ISOCode}
+	if loc.country != "" && len(record.Subdivisions) > 0 && record.Subdivisions[0].ISOCode != "" {
+		loc.region = loc.country + "-" + record.Subdivisions[0].ISOCode
+	}
+	return loc, nil
+}
+
+func (mr *mmdbReader) Close() error {
+	return mr.db.Close()
+}
+
+// geoIP locates visitors using a database file on local disk, never the network. The
+// file is reopened when it changes, so that it can be updated without a restart.
+type geoIP struct {
+	path    string
+	open    func(path string) (locationReader, error)
+	reader  locationReader
+	modTime time.Time
+	lock    sync.RWMutex
+}
+
+func newGeoIP(path string, open func(path string) (locationReader, error)) (*geoIP, error) {
+	g := &geoIP{path: path, open: open}
+	if _, err := g.reload(); err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return g, nil
+}
+
+// reload reopens the database if the file was modified since it was last opened. A file
+// that fails to open leaves the previous one in use.
+func (g *geoIP) reload() (bool, error) {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return false, err
+	}
+	g.lock.RLock()
+	unchanged := info.ModTime().Equal(g.modTime)
+	g.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	reader, err := g.open(g.path)
+	if err != nil {
+		return false, err
+	}
+	g.lock.Lock()
+	old := g.reader
+	g.reader, g.modTime = reader, info.ModTime()
+	g.lock.Unlock()
+
+	if old != nil { // Lookups hold the read lock, so none are still using it
+		_ = old.Close()
+	}
+	return true, nil
+}
+
+// watch checks for a new database file every interval
+func (g *geoIP) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for range ticker.C {
+			reloaded, err := g.reload()
+			if err != nil {
+				log.Printf("GeoIP database not reloaded: %v", err)
+			} else if reloaded {
+				log.Printf("GeoIP database reloaded from %s", g.path)
+			}
+		}
+	}()
+}
+
+func (g *geoIP) locate(ip net.IP) (location, error) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.reader.locate(ip)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeLocations places every address in the same location, and remembers being closed
+type fakeLocations struct {
+	loc    location
+	closed bool
+}
+
+func (fl *fakeLocations) locate(ip net.IP) (location, error) {
+	return fl.loc, nil
+}
+
+func (fl *fakeLocations) Close() error {
+	fl.closed = true
+	return nil
+}
+
+func newTestGeoIP(t *testing.T, loc location) (*geoIP, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	if err := os.WriteFile(path, []byte("v1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	geo, err := newGeoIP(path, func(string) (locationReader, error) { return &fakeLocations{loc: loc}, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return geo, path
+}
+
+func TestGeoIP_Reload(t *testing.T) {
+	geo, path := newTestGeoIP(t, location{country: "US"})
+	first := geo.reader.(*fakeLocations)
+
+	if reloaded, err := geo.reload(); err != nil || reloaded {
+		t.Fatalf("An unchanged file should not be reopened, got %v, %v", reloaded, err)
+	}
+
+	geo.open = func(string) (locationReader, error) { return &fakeLocations{loc: location{country: "DE"}}, nil }
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := geo.reload(); err != nil || !reloaded {
+		t.Fatalf("A modified file should be reopened, got %v, %v", reloaded, err)
+	}
+	if loc, _ := geo.locate(net.ParseIP("192.0.2.1")); loc.country != "DE" {
+		t.Errorf("Expected lookups to use the new database, got %q", loc.country)
+	}
+	if !first.closed {
+		t.Error("The replaced database should be closed")
+	}
+}
+
+func TestGeoIP_ReloadFailureKeepsDatabase(t *testing.T) {
+	geo, path := newTestGeoIP(t, location{country: "US"})
+
+	geo.open = func(string) (locationReader, error) { return nil, errors.New("truncated file") }
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := geo.reload(); err == nil {
+		t.Fatal("Expected the failed reload to be reported")
+	}
+	if loc, _ := geo.locate(net.ParseIP("192.0.2.1")); loc.country != "US" {
+		t.Errorf("Expected the previous database to stay in use, got %q", loc.country)
+	}
+}
+
+func TestServer_Follow_GeoRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.geo, _ = newTestGeoIP(t, location{country: "CA", region: "CA-QC"})
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "routes": [
+		{"region": "CA-ON", "url": "https://example.com/on"},
+		{"country": "ca", "url": "https://example.ca"}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	if got := rec.Header().Get("Location"); got != "https://example.ca" {
+		t.Errorf("Expected the visitor to be routed by country, got %s", got)
+	}
+
+	rec = doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/stats", nil)
+	var stats struct {
+		Countries map[string]uint64 `json:"countries"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Countries["CA"] != 1 {
+		t.Errorf("Expected the click to be attributed to CA, got %v", stats.Countries)
+	}
+}
+
+func TestServer_Shorten_InvalidRegion(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "routes": [{"region": "California", "url": "https://example.com/ca"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed region, got %d", rec.Code)
+	}
+}
+
+// addressLocations places only the addresses it knows of
+type addressLocations map[string]location
+
+func (al addressLocations) locate(ip net.IP) (location, error) {
+	return al[ip.String()], nil
+}
+
+func (al addressLocations) Close() error {
+	return nil
+}
+
+func TestServer_Follow_GeoRoutes_TrustedProxies(t *testing.T) {
+	for _, tc := range []struct {
+		trusted  []string
+		expected string
+	}{{nil, "https://example.com"}, {[]string{"192.0.2.0/24"}, "https://example.ca"}} {
+		cfg := defaultConfig()
+		cfg.trustedProxies = tc.trusted
+		db := &InMemoryUrlDb{}
+		s, err := newServer(gin.New(), &URLShortenerApp{urlRepo: db, idGenerator: newUniqueIDGenerator()}, db, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.geo, _ = newTestGeoIP(t, location{})
+		s.geo.reader = addressLocations{"203.0.113.7": {country: "CA"}}
+
+		rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "routes": [{"country": "ca", "url": "https://example.ca"}]}`)
+		req := httptest.NewRequest(http.MethodGet, "/"+decodeBody(t, rec)["shortUrl"], nil) // From 192.0.2.1
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Location"); got != tc.expected {
+			t.Errorf("With %v trusted, expected %s, got %s", tc.trusted, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// readinessTimeout bounds every readiness check, so a struggling dependency makes
+// /readyz fail fast rather than hang
+const readinessTimeout = 1 * time.Second
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// runningChecker is implemented by components with background work that can stop
+type runningChecker interface {
+	Running() bool
+}
+
+// schemaChecker is implemented by repos whose schema is managed by migrations
+type schemaChecker interface {
+	CheckSchema(ctx context.Context) error
+}
+
+func (s *server) readinessChecks() []healthCheck {
+	checks := []healthCheck{{"db", s.db.Ping}}
+
+	if rc, ok := s.app.idGenerator.(runningChecker); ok {
+		checks = append(checks, healthCheck{"generator", func(ctx context.Context) error {
+			if !rc.Running() {
+				return errors.New("id generator has stopped resetting its sequence")
+			}
+			return nil
+		}})
+	}
+	if sc, ok := s.db.(schemaChecker); ok {
+		checks = append(checks, healthCheck{"migrations", sc.CheckSchema})
+	}
+
+	return checks
+}
+
+// handleLive reports that the process is up and serving requests. It deliberately
+// checks nothing else, so that a database outage doesn't get the container restarted.
+func (s *server) handleLive() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	}
+}
+
+// handleReady reports whether this instance can serve traffic, with the outcome of
+// each check
+func (s *server) handleReady() gin.HandlerFunc {
+	checks := s.readinessChecks()
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		defer cancel()
+
+		results := make([]gin.H, len(checks))
+		var wg sync.WaitGroup
+		for i, hc := range checks {
+			wg.Add(1)
+			go func(i int, hc healthCheck) {
+				defer wg.Done()
+				if err := hc.check(ctx); err != nil {
+					results[i] = gin.H{"status": "error", "error": err.Error()}
+					return
+				}
+				results[i] = gin.H{"status": "ok"}
+			}(i, hc)
+		}
+		wg.Wait()
+
+		status, code := "ok", http.StatusOK
+		detail := gin.H{}
+		for i, hc := range checks {
+			detail[hc.name] = results[i]
+			if results[i]["status"] != "ok" {
+				status, code = "error", http.StatusServiceUnavailable
+			}
+		}
+		c.JSON(code, gin.H{"status": status, "checks": detail})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"testing"
+)
+
+type unreachableUrlDb struct {
+	*InMemoryUrlDb
+}
+
+func (u *unreachableUrlDb) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func TestServer_Live(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodGet, "/livez", nil)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+}
+
+func TestServer_Ready(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodGet, "/readyz", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Status string                       `json:"status"`
+		Checks map[string]map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Checks["db"]["status"] != "ok" || body.Checks["generator"]["status"] != "ok" {
+		t.Errorf("Expected passing db and generator checks, got %v", body.Checks)
+	}
+}
+
+func TestServer_Ready_DBDown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := &unreachableUrlDb{&InMemoryUrlDb{}}
+	app := &URLShortenerApp{urlRepo: db, idGenerator: newUniqueIDGenerator()}
+	s, err := newServer(gin.New(), app, db, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := doRequest(s, http.MethodGet, "/readyz", nil)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503, got %d", rec.Code)
+	}
+
+	var body struct {
+		Checks map[string]map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Checks["db"]["error"] != "connection refused" {
+		t.Errorf("Expected the db check to report its error, got %v", body.Checks)
+	}
+
+	if rec := doRequest(s, http.MethodGet, "/livez", nil); rec.Code != http.StatusOK {
+		t.Error("Liveness should not depend on the database")
+	}
+}
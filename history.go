@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrVersionConflict is returned by UpdateLink when another update got to the version first
+var ErrVersionConflict = errors.New("link was changed by another update")
+
+// actorHeader names who is making a change. The API has no accounts, only the admin token
+// that changes need, so it tells apart the people sharing that token.
+const actorHeader = "X-Actor"
+
+// LinkVersion is the state of a link after a change, along with who made it and what
+// it changed. Version 1 is the link as it was created, recorded by its first update.
+type LinkVersion struct {
+	Version   int
+	Actor     string
+	ChangedAt time.Time
+	LongUrl   string
+	Settings  LinkSettings
+	Diff      map[string]FieldChange
+}
+
+// FieldChange is a change to one field of a link, with values as they appear in JSON
+type FieldChange struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// linkFields flattens a link into the fields a version diff compares
+func linkFields(longUrl string, settings LinkSettings) (map[string]json.RawMessage, error) {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	fields["longUrl"], _ = json.Marshal(longUrl)
+	return fields, nil
+}
+
+// diffLinks reports the fields that differ between two states of a link, with null
+// standing for a field that isn't set
+func diffLinks(fromUrl string, from LinkSettings, toUrl string, to LinkSettings) (map[string]FieldChange, error) {
+	before, err := linkFields(fromUrl, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := linkFields(toUrl, to)
+	if err != nil {
+		return nil, err
+	}
+
+	null := json.RawMessage("null")
+	diff := map[string]FieldChange{}
+	for name, value := range before {
+		if !bytes.Equal(value, after[name]) {
+			diff[name] = FieldChange{From: value, To: null}
+		}
+	}
+	for name, value := range after {
+		if change, exists := diff[name]; exists {
+			change.To = value
+			diff[name] = change
+		} else if _, existed := before[name]; !existed {
+			diff[name] = FieldChange{From: null, To: value}
+		}
+	}
+	if change, exists := diff["passwordHash"]; exists { // Show that it changed, but not the hashes
+		delete(diff, "passwordHash")
+		hidden := json.RawMessage(`"(hidden)"`)
+		if from.PasswordHash != "" {
+			change.From = hidden
+		}
+		if to.PasswordHash != "" {
+			change.To = hidden
+		}
+		diff["password"] = change
+	}
+	return diff, nil
+}
+
+// linkHistory returns the versions of link, oldest first. A link that was never updated
+// has the one version it was created as.
+func (app *URLShortenerApp) linkHistory(link Link) ([]LinkVersion, error) {
+	history, err := app.urlRepo.LinkHistory(link.Id)
+	if err != nil || len(history) > 0 {
+		return history, err
+	}
+	return []LinkVersion{createdVersion(link)}, nil
+}
+
+// createdVersion is the first version of link, as it was created
+func createdVersion(link Link) LinkVersion {
+	return LinkVersion{Version: 1, ChangedAt: link.CreatedAt(), LongUrl: link.LongUrl, Settings: link.Settings}
+}
+
+// updateLink replaces link's URL and settings with those of updated, recording the
+// change as a new version. It returns the version, which is zero if nothing changed.
+func (app *URLShortenerApp) updateLink(link Link, updated Link, actor string) (LinkVersion, error) {
+	if updated.LongUrl == link.LongUrl && reflect.DeepEqual(updated.Settings, link.Settings) {
+		return LinkVersion{}, nil
+	}
+	diff, err := diffLinks(link.LongUrl, link.Settings, updated.LongUrl, updated.Settings)
+	if err != nil {
+		return LinkVersion{}, err
+	}
+
+	history, err := app.urlRepo.LinkHistory(link.Id)
+	if err != nil {
+		return LinkVersion{}, err
+	}
+	var versions []LinkVersion
+	if len(history) == 0 { // Keep the link as it was created, so that it can be rolled back to
+		history = []LinkVersion{createdVersion(link)}
+		versions = history
+	}
+
+	version := LinkVersion{
+		Version:   history[len(history)-1].Version + 1,
+		Actor:     actor,
+		ChangedAt: time.Now().UTC().Truncate(time.Second),
+		LongUrl:   updated.LongUrl,
+		Settings:  updated.Settings,
+		Diff:      diff,
+	}
+	versions = append(versions, version)
+	if err = app.urlRepo.UpdateLink(Link{Id: link.Id, LongUrl: updated.LongUrl, Settings: updated.Settings}, versions); err != nil {
+		return LinkVersion{}, err
+	}
+	return version, nil
+}
+
+// linkPatchFields are the fields of an update that aren't settings
+var linkPatchFields = map[string]bool{"longUrl": true, "password": true}
+
+// applyPatch returns link with the fields in patch replaced, following JSON merge patch:
+// fields that are left out stay as they are and null resets a field
+func applyPatch(link Link, patch map[string]json.RawMessage) (Link, error) {
+	fields := map[string]json.RawMessage{}
+	raw, err := json.Marshal(link.Settings)
+	if err != nil {
+		return Link{}, err
+	}
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return Link{}, err
+	}
+
+	for name, value := range patch {
+		if name == "passwordHash" {
+			return Link{}, fmt.Errorf("unknown field %q", name)
+		}
+		if linkPatchFields[name] {
+			continue
+		}
+		if string(value) == "null" {
+			delete(fields, name)
+		} else {
+			fields[name] = value
+		}
+	}
+
+	raw, err = json.Marshal(fields)
+	if err != nil {
+		return Link{}, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	updated := Link{Id: link.Id, LongUrl: link.LongUrl, Clicks: link.Clicks}
+	if err = decoder.Decode(&updated.Settings); err != nil {
+		return Link{}, err
+	}
+
+	if value, exists := patch["longUrl"]; exists {
+		if err = json.Unmarshal(value, &updated.LongUrl); err != nil || updated.LongUrl == "" {
+			return Link{}, errors.New("`longUrl` must be a non-empty string")
+		}
+	}
+	if value, exists := patch["password"]; exists {
+		var password *string
+		if err = json.Unmarshal(value, &password); err != nil {
+			return Link{}, errors.New("`password` must be a string or null")
+		}
+		updated.Settings.PasswordHash = ""
+		if password != nil && *password != "" {
+			if err = validatePassword(*password); err != nil {
+				return Link{}, err
+			}
+			if updated.Settings.PasswordHash, err = hashPassword(*password); err != nil {
+				return Link{}, err
+			}
+		}
+	}
+	updated.Settings.Tags = normalizeTags(updated.Settings.Tags)
+	updated.Settings.NotBefore = windowTime(updated.Settings.NotBefore)
+	updated.Settings.NotAfter = windowTime(updated.Settings.NotAfter)
+	return updated, nil
+}
+
+func validateSettings(settings LinkSettings) error {
+	if err := validateDestinations(settings.Destinations); err != nil {
+		return err
+	}
+	if err := validateRoutes(settings.Routes); err != nil {
+		return err
+	}
+	if err := validateWindow(settings); err != nil {
+		return err
+	}
+	if err := validateDescription(settings); err != nil {
+		return err
+	}
+	if settings.UTM != nil {
+		if err := validateUTM(*settings.UTM); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionResponse shows a version without the password hash
+type versionResponse struct {
+	Version   int                    `json:"version"`
+	Actor     string                 `json:"actor,omitempty"`
+	ChangedAt *time.Time             `json:"changedAt,omitempty"` // Unknown for the first version of a counter id
+	LongUrl   string                 `json:"longUrl"`
+	Settings  LinkSettings           `json:"settings"`
+	Protected bool                   `json:"protected"`
+	Diff      map[string]FieldChange `json:"diff,omitempty"`
+}
+
+func newVersionResponse(v LinkVersion) versionResponse {
+	settings := v.Settings
+	settings.PasswordHash = ""
+	return versionResponse{
+		Version:   v.Version,
+		Actor:     v.Actor,
+		ChangedAt: knownTime(v.ChangedAt),
+		LongUrl:   v.LongUrl,
+		Settings:  settings,
+		Protected: v.Settings.PasswordHash != "",
+		Diff:      v.Diff,
+	}
+}
+
+// updateLinkAndRespond applies updated to link, answering with the new version
+func (s *server) updateLinkAndRespond(c *gin.Context, link Link, updated Link) {
+	if err := validateSettings(updated.Settings); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version, err := s.app.updateLink(link, updated, c.GetHeader(actorHeader))
+	if errors.Is(err, ErrDuplicateURL) || errors.Is(err, ErrVersionConflict) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if version.Version == 0 {
+		c.JSON(http.StatusOK, gin.H{"changed": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changed": true, "version": newVersionResponse(version)})
+}
+
+// findLinkJSON looks up the link named in the path, answering with an error if that fails
+func (s *server) findLinkJSON(c *gin.Context) (Link, bool) {
+	link, err := s.app.lookup(c.Param("code"))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return Link{}, false
+	}
+	if !link.Exists() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "shortUrl not known"})
+		return Link{}, false
+	}
+	return link, true
+}
+
+func (s *server) handleUpdateLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, ok := s.findLinkJSON(c)
+		if !ok {
+			return
+		}
+		var patch map[string]json.RawMessage
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "malformed request: " + err.Error()})
+			return
+		}
+		updated, err := applyPatch(link, patch)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "malformed request: " + err.Error()})
+			return
+		}
+		s.updateLinkAndRespond(c, link, updated)
+	}
+}
+
+// handleHistory lists the versions of a link newest first. It's for admins only, as old
+// versions give away destinations that the link itself may withhold.
+func (s *server) handleHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, ok := s.findLinkJSON(c)
+		if !ok {
+			return
+		}
+		history, err := s.app.linkHistory(link)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		sort.Slice(history, func(i, j int) bool { return history[i].Version > history[j].Version }) // Newest first
+		versions := make([]versionResponse, 0, len(history))
+		for _, v := range history {
+			versions = append(versions, newVersionResponse(v))
+		}
+		c.JSON(http.StatusOK, gin.H{"versions": versions})
+	}
+}
+
+// handleRollback makes a new version with the URL and settings of an earlier one. The
+// settings include the password, so rolling back past a change of password restores
+// the old one, and rolling back to before a link was protected removes its password.
+func (s *server) handleRollback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, ok := s.findLinkJSON(c)
+		if !ok {
+			return
+		}
+		target, err := strconv.Atoi(c.Param("version"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "version must be a number"})
+			return
+		}
+		history, err := s.app.linkHistory(link)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		for _, v := range history {
+			if v.Version == target {
+				s.updateLinkAndRespond(c, link, Link{Id: link.Id, LongUrl: v.LongUrl, Settings: v.Settings})
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no such version"})
+	}
+}
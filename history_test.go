@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func patchLink(s *server, shortUrl string, body string, actor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/links/"+shortUrl, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(actorHeader, actor)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func historyForTest(t *testing.T, s *server, shortUrl string) []versionResponse {
+	t.Helper()
+	rec := doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/history", asAdmin())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from history, got %d", rec.Code)
+	}
+	var body struct {
+		Versions []versionResponse `json:"versions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Versions
+}
+
+func TestServer_UpdateLink_History(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://example.com/old")
+
+	if versions := historyForTest(t, s, shortUrl); len(versions) != 1 || versions[0].LongUrl != "https://example.com/old" {
+		t.Fatalf("A new link should have its creation as its only version, got %+v", versions)
+	}
+
+	rec := patchLink(s, shortUrl, `{"longUrl": "https://example.com/new", "interstitial": true}`, "alice")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from update, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := doRequest(s, http.MethodGet, "/"+shortUrl, nil); !strings.Contains(got.Body.String(), "https://example.com/new") {
+		t.Error("The link should lead to its new destination")
+	}
+
+	versions := historyForTest(t, s, shortUrl)
+	if len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %d", len(versions))
+	}
+	latest := versions[0]
+	if latest.Version != 2 || latest.Actor != "alice" || latest.LongUrl != "https://example.com/new" {
+		t.Errorf("Unexpected latest version %+v", latest)
+	}
+	if string(latest.Diff["longUrl"].From) != `"https://example.com/old"` || string(latest.Diff["interstitial"].To) != "true" {
+		t.Errorf("Unexpected diff %v", latest.Diff)
+	}
+}
+
+func TestServer_UpdateLink_Rollback(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://example.com/old")
+	patchLink(s, shortUrl, `{"longUrl": "https://example.com/new"}`, "alice")
+
+	rec := doRequest(s, http.MethodPost, "/api/v1/links/"+shortUrl+"/history/1/rollback", asAdmin())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from rollback, got %d: %s", rec.Code, rec.Body.String())
+	}
+	link, _ := s.app.lookup(shortUrl)
+	if link.LongUrl != "https://example.com/old" {
+		t.Errorf("Expected the link to be rolled back, got %s", link.LongUrl)
+	}
+	if versions := historyForTest(t, s, shortUrl); len(versions) != 3 {
+		t.Errorf("A rollback should be recorded as a new version, got %d versions", len(versions))
+	}
+
+	rec = doRequest(s, http.MethodPost, "/api/v1/links/"+shortUrl+"/history/9/rollback", asAdmin())
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown version, got %d", rec.Code)
+	}
+}
+
+func TestServer_UpdateLink_RollbackNeverUpdated(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://example.com")
+
+	rec := doRequest(s, http.MethodPost, "/api/v1/links/"+shortUrl+"/history/1/rollback", asAdmin())
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"changed":false}` {
+		t.Errorf("Expected rolling back to the listed version 1 to change nothing, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServer_UpdateLink_PasswordHidden(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://example.com")
+
+	patchLink(s, shortUrl, `{"password": "hunter2"}`, "")
+	versions := historyForTest(t, s, shortUrl)
+	if !versions[0].Protected || string(versions[0].Diff["password"].To) != `"(hidden)"` {
+		t.Errorf("Expected the password change to be recorded without the hash, got %+v", versions[0])
+	}
+	if _, exists := versions[0].Diff["passwordHash"]; exists {
+		t.Error("The password hash should not appear in the diff")
+	}
+}
+
+func TestServer_UpdateLink_Invalid(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://example.com/a")
+	shortenForTest(t, s, "https://example.com/b")
+
+	tests := map[string]int{
+		`{"longUrl": "https://example.com/b"}`: http.StatusConflict,
+		`{"passwordHash": "x"}`:                http.StatusBadRequest,
+		`{"colour": "red"}`:                    http.StatusBadRequest,
+		`{"longUrl": ""}`:                      http.StatusBadRequest,
+		`{"routes": [{"os": "symbian", "url": "https://example.com/s60"}]}`: http.StatusBadRequest,
+	}
+	for body, want := range tests {
+		if rec := patchLink(s, shortUrl, body, ""); rec.Code != want {
+			t.Errorf("Expected %d for %s, got %d", want, body, rec.Code)
+		}
+	}
+}
+
+func TestServer_History_AdminOnly(t *testing.T) {
+	s := newTestServer(t)
+	protected := shortenProtectedForTest(t, s)
+	limited := shortenWindowForTest(t, s, "https://example.com/limited", `"maxClicks": 1`)
+	pending := shortenWindowForTest(t, s, "https://example.com/pending", `"notBefore": "`+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+`"`)
+
+	for _, shortUrl := range []string{protected, limited, pending} {
+		rec := doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/history", nil)
+		if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "example.com") {
+			t.Errorf("Expected 401 from the history of %s without the admin token, got %d: %s", shortUrl, rec.Code, rec.Body.String())
+		}
+		if rec = doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/history", asAdmin()); rec.Code != http.StatusOK {
+			t.Errorf("Expected 200 from the history of %s for an admin, got %d", shortUrl, rec.Code)
+		}
+	}
+}
+
+func TestServer_UpdateLink_AdminOnly(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/links/"+shortUrl, strings.NewReader(`{"password": null}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 from an update without the admin token, got %d", rec.Code)
+	}
+	if rec = doRequest(s, http.MethodPost, "/api/v1/links/"+shortUrl+"/history/1/rollback", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 from a rollback without the admin token, got %d", rec.Code)
+	}
+	if rec = doRequest(s, http.MethodGet, "/"+shortUrl, nil); rec.Code == http.StatusFound {
+		t.Error("The link should still be protected")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const idempotencyHeader = "Idempotency-Key"
+const maxIdempotencyKeyLen = 255
+
+type idempotencyState int
+
+const (
+	idempotencyNew idempotencyState = iota
+	idempotencyInFlight
+	idempotencyMismatch
+	idempotencyDone
+)
+
+type idempotencyEntry struct {
+	fingerprint string
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+// idempotencyStore remembers responses to requests sent with an Idempotency-Key so
+// that a retried request is answered with the original response instead of being
+// processed again. Entries live in memory, so keys are only honored by the instance
+// that first saw them.
+type idempotencyStore struct {
+	window    time.Duration
+	entries   map[string]*idempotencyEntry
+	lastSweep time.Time
+	lock      sync.Mutex
+	now       func() time.Time
+}
+
+func newIdempotencyStore(window time.Duration) *idempotencyStore {
+	return &idempotencyStore{
+		window:  window,
+		entries: make(map[string]*idempotencyEntry),
+		now:     time.Now,
+	}
+}
+
+// begin claims key for a request with the given fingerprint. The returned entry is
+// only meaningful when the state is idempotencyDone.
+func (is *idempotencyStore) begin(key string, fingerprint string) (idempotencyEntry, idempotencyState) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	now := is.now()
+	is.sweep(now)
+
+	entry, exists := is.entries[key]
+	if exists && now.After(entry.expires) {
+		delete(is.entries, key)
+		exists = false
+	}
+
+	switch {
+	case !exists:
+		is.entries[key] = &idempotencyEntry{fingerprint: fingerprint, expires: now.Add(is.window)}
+		return idempotencyEntry{}, idempotencyNew
+	case entry.fingerprint != fingerprint:
+		return idempotencyEntry{}, idempotencyMismatch
+	case !entry.done:
+		return idempotencyEntry{}, idempotencyInFlight
+	default:
+		return *entry, idempotencyDone
+	}
+}
+
+// complete stores the response for key, to be replayed until the window elapses
+func (is *idempotencyStore) complete(key string, status int, contentType string, body []byte) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+
+	if entry, exists := is.entries[key]; exists {
+		entry.done = true
+		entry.status = status
+		entry.contentType = contentType
+		entry.body = body
+	}
+}
+
+// abandon releases key so that the request can be retried, e.g. after a server error
+func (is *idempotencyStore) abandon(key string) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+	delete(is.entries, key)
+}
+
+// sweep drops expired entries, at most once a minute. Caller must hold the lock.
+func (is *idempotencyStore) sweep(now time.Time) {
+	if now.Sub(is.lastSweep) < time.Minute {
+		return
+	}
+	is.lastSweep = now
+	for key, entry := range is.entries {
+		if now.After(entry.expires) {
+			delete(is.entries, key)
+		}
+	}
+}
+
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recordingWriter) WriteString(s string) (int, error) {
+	rw.body.WriteString(s)
+	return rw.ResponseWriter.WriteString(s)
+}
+
+// requestFingerprint identifies what a request asks for, so that reusing a key for a
+// different request can be rejected rather than answered with an unrelated response
+func requestFingerprint(c *gin.Context) (string, error) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	h := sha256.New()
+	h.Write([]byte(c.Request.Method + " " + c.FullPath() + "?" + c.Request.URL.Query().Encode() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *server) idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "header `Idempotency-Key` is too long"})
+			return
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+
+		entry, state := s.idempotency.begin(key, fingerprint)
+		switch state {
+		case idempotencyMismatch:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "`Idempotency-Key` was already used for a different request"})
+			return
+		case idempotencyInFlight:
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this `Idempotency-Key` is still in progress"})
+			return
+		case idempotencyDone:
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(entry.status, entry.contentType, entry.body)
+			c.Abort()
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = rw
+		finished := false
+		defer func() {
+			if !finished { // Handler panicked, let the key be retried
+				s.idempotency.abandon(key)
+			}
+		}()
+
+		c.Next()
+		finished = true
+
+		// Server errors are not remembered so that the client can retry them
+		if status := rw.Status(); status >= http.StatusInternalServerError {
+			s.idempotency.abandon(key)
+		} else {
+			s.idempotency.complete(key, status, rw.Header().Get("Content-Type"), rw.body.Bytes())
+		}
+	}
+}
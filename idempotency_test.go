@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIdempotencyStore_Lifecycle(t *testing.T) {
+	is := newIdempotencyStore(time.Hour)
+
+	if _, state := is.begin("key", "fp"); state != idempotencyNew {
+		t.Fatal("First use of a key should be new")
+	}
+	if _, state := is.begin("key", "fp"); state != idempotencyInFlight {
+		t.Error("Key should be in flight until completed")
+	}
+	if _, state := is.begin("key", "other"); state != idempotencyMismatch {
+		t.Error("Key reused with a different fingerprint should mismatch")
+	}
+
+	is.complete("key", 200, "application/json", []byte("{}"))
+	entry, state := is.begin("key", "fp")
+	if state != idempotencyDone {
+		t.Fatal("Completed key should be replayed")
+	}
+	if entry.status != 200 || string(entry.body) != "{}" {
+		t.Error("Replayed entry should hold the stored response")
+	}
+}
+
+func TestIdempotencyStore_Abandon(t *testing.T) {
+	is := newIdempotencyStore(time.Hour)
+
+	is.begin("key", "fp")
+	is.abandon("key")
+
+	if _, state := is.begin("key", "fp"); state != idempotencyNew {
+		t.Error("Abandoned key should be usable again")
+	}
+}
+
+func TestIdempotencyStore_Expiry(t *testing.T) {
+	now := time.Now()
+	is := newIdempotencyStore(time.Minute)
+	is.now = func() time.Time { return now }
+
+	is.begin("key", "fp")
+	is.complete("key", 200, "application/json", []byte("{}"))
+
+	now = now.Add(2 * time.Minute)
+	if _, state := is.begin("key", "fp"); state != idempotencyNew {
+		t.Error("Key should be forgotten once its window has elapsed")
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Layouts of ids, reported so that callers can tell them apart. A timestamp id is a 32
+// bit Unix timestamp, a padding bit and a 23 bit sequence within that second. A counter
+// id is a number from a sequence in the database, without a time.
+const (
+	idFormatTimestamp = 1
+	idFormatCounter   = 2
+)
+
+// idInfo is what a short URL tells about its link without looking it up
+type idInfo struct {
+	ShortUrl  string     `json:"shortUrl"`
+	Format    int        `json:"format"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"` // Timestamp ids only
+	Timestamp uint32     `json:"timestamp,omitempty"` // Timestamp ids only
+	Sequence  uint64     `json:"sequence"`
+}
+
+func newIdInfo(id UrlId, shortUrl string) idInfo {
+	if isCounterId(id) {
+		return idInfo{ShortUrl: shortUrl, Format: idFormatCounter, Sequence: idValue(id)}
+	}
+	seconds, seq := decodeID(id)
+	createdAt := time.Unix(int64(seconds), 0).UTC()
+	return idInfo{
+		ShortUrl:  shortUrl,
+		Format:    idFormatTimestamp,
+		CreatedAt: &createdAt,
+		Timestamp: seconds,
+		Sequence:  uint64(seq),
+	}
+}
+
+// linkMetadata is what is stored about when and how much a link can be used. Links have
+// no owner, so there's none to report.
+type linkMetadata struct {
+	State     string     `json:"state"` // active, pending, expired or exhausted
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	MaxClicks uint64     `json:"maxClicks,omitempty"`
+	Clicks    uint64     `json:"clicks"`
+	Protected bool       `json:"protected"`
+}
+
+func newLinkMetadata(link Link, now time.Time) linkMetadata {
+	metadata := linkMetadata{
+		State:     "active",
+		NotBefore: link.Settings.NotBefore,
+		ExpiresAt: link.Settings.NotAfter,
+		MaxClicks: link.Settings.MaxClicks,
+		Clicks:    link.Clicks,
+		Protected: link.Settings.PasswordHash != "",
+	}
+	switch {
+	case link.window(now) == windowPending:
+		metadata.State = "pending"
+	case link.window(now) == windowClosed:
+		metadata.State = "expired"
+	case link.Exhausted():
+		metadata.State = "exhausted"
+	}
+	return metadata
+}
+
+// errUnauthorized is returned for an admin token that doesn't match the configured one
+var errUnauthorized = errors.New("invalid admin token")
+
+// errAdminOnly is returned for a request without the admin token that needs it
+var errAdminOnly = errors.New("the admin token is required")
+
+// isAdmin reports whether the request carries the admin token as a bearer token. Giving
+// a token that doesn't match is an error rather than being treated as no token, so that
+// a misconfigured caller finds out.
+func (s *server) isAdmin(c *gin.Context) (bool, error) {
+	header := c.GetHeader("Authorization")
+	if header == "" {
+		return false, nil
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	if len(s.adminToken) == 0 || token == header || subtle.ConstantTimeCompare([]byte(token), s.adminToken) != 1 {
+		return false, errUnauthorized
+	}
+	return true, nil
+}
+
+// adminOnly answers 401 to requests that don't carry the admin token
+func (s *server) adminOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if admin, err := s.isAdmin(c); !admin {
+			if err == nil {
+				err = errAdminOnly
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		}
+	}
+}
+
+// handleInfo decodes a short URL and reports whether its link exists. Admins also get
+// the link's metadata.
+func (s *server) handleInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ids := s.app.codecs().decode(c.Param("code"))
+		if len(ids) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "not a valid short URL"})
+			return
+		}
+		admin, err := s.isAdmin(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		link, err := s.app.lookup(c.Param("code"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		id := ids[0]
+		if link.Exists() { // The code may be valid in more than one codec
+			id = link.Id
+		}
+		resp := gin.H{"id": newIdInfo(id, s.app.shortCode(id)), "exists": link.Exists()}
+		if admin && link.Exists() {
+			resp["metadata"] = newLinkMetadata(link, time.Now())
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServer_Info(t *testing.T) {
+	s := newTestServer(t)
+	s.adminToken = []byte("secret")
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "maxClicks": 5, "notAfter": "2999-01-01T00:00:00Z"}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	var body struct {
+		Id       idInfo        `json:"id"`
+		Exists   bool          `json:"exists"`
+		Metadata *linkMetadata `json:"metadata"`
+	}
+	rec = doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/info", nil)
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if !body.Exists || body.Id.Format != idFormatTimestamp || body.Id.CreatedAt == nil || time.Since(*body.Id.CreatedAt) > time.Minute {
+		t.Errorf("Unexpected info %+v", body)
+	}
+	if body.Metadata != nil {
+		t.Error("Metadata should only be shown to admins")
+	}
+
+	rec = doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/info", http.Header{"Authorization": {"Bearer secret"}})
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Metadata == nil || body.Metadata.State != "active" || body.Metadata.MaxClicks != 5 || body.Metadata.ExpiresAt.Year() != 2999 {
+		t.Errorf("Unexpected metadata %+v", body.Metadata)
+	}
+
+	if rec = doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/info", http.Header{"Authorization": {"Bearer guess"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for the wrong token, got %d", rec.Code)
+	}
+	if rec = doRequest(s, http.MethodGet, "/api/v1/links/short/info", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed code, got %d", rec.Code)
+	}
+}
+
+func TestServer_Info_Unknown(t *testing.T) {
+	s := newTestServer(t)
+	id := UrlId{}
+	_ = encodeID(&id, 1714085905, 42)
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/links/"+encodeBase62(id)+"/info", http.Header{"Authorization": {"Bearer "}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when no admin token is configured, got %d", rec.Code)
+	}
+	rec = doRequest(s, http.MethodGet, "/api/v1/links/"+encodeBase62(id)+"/info", nil)
+	expected := `{"exists":false,"id":{"shortUrl":"` + encodeBase62(id) + `","format":1,"createdAt":"2024-04-25T22:58:25Z","timestamp":1714085905,"sequence":42}}`
+	if rec.Body.String() != expected {
+		t.Errorf("Unexpected info %s", rec.Body.String())
+	}
+}
+
+func TestCLI_Info(t *testing.T) {
+	db := &InMemoryUrlDb{}
+	if err := db.StoreLink(Link{Id: UrlId{1, 2, 3, 4, 5}, LongUrl: "https://example.com", Settings: LinkSettings{MaxClicks: 3}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, stdout, _ := newTestCLI(db, "")
+	if code := c.run([]string{"info", encodeBase62(UrlId{1, 2, 3, 4, 5})}); code != exitOK {
+		t.Fatalf("Expected exit code %d, got %d", exitOK, code)
+	}
+	if !strings.Contains(stdout.String(), "exists:    yes\nstate:     active\nclicks:    0 of 3\n") {
+		t.Errorf("Unexpected info output %q", stdout.String())
+	}
+
+	c, _, _ = newTestCLI(db, "")
+	if code := c.run([]string{"info", "EjEI4qOkHp"}); code != exitNotFound {
+		t.Errorf("Expected exit code %d, got %d", exitNotFound, code)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// LinkSettings holds optional per-link behavior, stored alongside the URL record
+type LinkSettings struct {
+	Interstitial bool          `json:"interstitial,omitempty"` // Show the preview page instead of redirecting
+	PasswordHash string        `json:"passwordHash,omitempty"` // bcrypt, visitors must enter the password first
+	MaxClicks    uint64        `json:"maxClicks,omitempty"`    // Link stops working after this many visits, 0 for no limit
+	Destinations []Destination `json:"destinations,omitempty"` // Split visits between these instead of the long URL
+	Sticky       bool          `json:"sticky,omitempty"`       // Send returning visitors to the destination they got before
+	Routes       []Route       `json:"routes,omitempty"`       // Checked in order, visitors matching none go to the usual destination
+	Passthrough  bool          `json:"passthrough,omitempty"`  // Append the path and query visitors add to the short URL
+	UTM          *UTM          `json:"utm,omitempty"`          // Added to the destination's query when followed
+	NotBefore    *time.Time    `json:"notBefore,omitempty"`    // Link doesn't lead anywhere before this
+	NotAfter     *time.Time    `json:"notAfter,omitempty"`     // Nor after this
+	PendingURL   string        `json:"pendingUrl,omitempty"`   // Where to send visitors before NotBefore, instead of a page
+
+	// Descriptive only, for finding links again
+	Title string   `json:"title,omitempty"`
+	Notes string   `json:"notes,omitempty"`
+	Tags  []string `json:"tags,omitempty"` // Normalized by normalizeTags
+}
+
+// withoutDescription returns the settings that affect how the link behaves
+func (ls LinkSettings) withoutDescription() LinkSettings {
+	ls.Title, ls.Notes, ls.Tags = "", "", nil
+	return ls
+}
+
+func (ls LinkSettings) IsZero() bool {
+	return reflect.DeepEqual(ls, LinkSettings{})
+}
+
+// marshalSettings encodes settings for storage, as NULL when there are none
+func marshalSettings(ls LinkSettings) ([]byte, error) {
+	if ls.IsZero() {
+		return nil, nil
+	}
+	return json.Marshal(ls)
+}
+
+func unmarshalSettings(raw []byte) (LinkSettings, error) {
+	var ls LinkSettings
+	if len(raw) == 0 {
+		return ls, nil
+	}
+	err := json.Unmarshal(raw, &ls)
+	return ls, err
+}
+
+// Click describes a single visit to a link
+type Click struct {
+	Variant string // Destination chosen for links with several, empty otherwise
+	Country string // Where the visitor was located, empty if unknown
+}
+
+// ClickBreakdown counts the clicks on a link by what they were attributed to
+type ClickBreakdown struct {
+	Variants  map[string]uint64 `json:"variants"`
+	Countries map[string]uint64 `json:"countries"`
+}
+
+type Link struct {
+	Id       UrlId
+	LongUrl  string
+	Settings LinkSettings
+	Clicks   uint64
+}
+
+func (l Link) Exists() bool {
+	return l.Id != UrlId{}
+}
+
+// Exhausted reports whether the link has used up its clicks
+func (l Link) Exhausted() bool {
+	return l.Settings.MaxClicks > 0 && l.Clicks >= l.Settings.MaxClicks
+}
+
+type linkWindow int
+
+const (
+	windowOpen    linkWindow = iota
+	windowPending            // Before NotBefore
+	windowClosed             // After NotAfter
+)
+
+// window reports whether the link is active at now
+func (l Link) window(now time.Time) linkWindow {
+	if l.Settings.NotBefore != nil && now.Before(*l.Settings.NotBefore) {
+		return windowPending
+	}
+	if l.Settings.NotAfter != nil && !now.Before(*l.Settings.NotAfter) {
+		return windowClosed
+	}
+	return windowOpen
+}
+
+// Limited reports whether the link can only be followed a number of times. Its
+// destination is only given out by following it, so that every look uses up a click.
+func (l Link) Limited() bool {
+	return l.Settings.MaxClicks > 0
+}
+
+// Withheld reports whether the link's destinations are kept from anyone who hasn't got
+// through to them: it's protected, not active yet, or Limited
+func (l Link) Withheld(now time.Time) bool {
+	return l.Settings.PasswordHash != "" || l.window(now) == windowPending || l.Limited()
+}
+
+// CreatedAt is the time the link's id was generated, zero for a counter id as those don't
+// record one
+func (l Link) CreatedAt() time.Time {
+	if isCounterId(l.Id) {
+		return time.Time{}
+	}
+	seconds, _ := decodeID(l.Id)
+	return time.Unix(int64(seconds), 0).UTC()
+}
+
+// knownTime is t for a response, where it's left out if zero as CreatedAt can be
+func knownTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxTitleLen     = 255
+	maxNotesLen     = 4096
+	maxTags         = 20
+	defaultListSize = 100
+	maxListSize     = 1000
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.:-]{0,49}$`)
+
+// LinkFilter selects the links to list. Ids are ordered by creation time, so the time
+// range is a range of ids and needs no index of its own. Counter ids have no time and
+// come before every timestamp id.
+type LinkFilter struct {
+	After UrlId  // Only links with a greater id, the cursor of the previous page
+	From  UrlId  // Only links with this id or greater, if set
+	To    UrlId  // Only links with a smaller id, if set
+	Tag   string // Only links with this tag, if set
+	Host  string // Only links whose destination's host contains this, if set
+	Limit int
+}
+
+// matches reports whether link passes the filter, for stores that can't filter as they read
+func (f LinkFilter) matches(link Link) bool {
+	switch {
+	case bytes.Compare(link.Id[:], f.After[:]) <= 0:
+		return false
+	case bytes.Compare(link.Id[:], f.From[:]) < 0:
+		return false
+	case f.To != UrlId{} && bytes.Compare(link.Id[:], f.To[:]) >= 0:
+		return false
+	case f.Tag != "" && !containsString(link.Settings.Tags, f.Tag):
+		return false
+	case f.Host != "" && !strings.Contains(urlHost(link.LongUrl), f.Host):
+		return false
+	}
+	return true
+}
+
+// firstIdAt is the smallest id that can be generated at t, to the second
+func firstIdAt(t time.Time) UrlId {
+	id := UrlId{}
+	_ = encodeID(&id, uint32(t.Unix()), 0)
+	return id
+}
+
+// urlHost extracts the lower cased host of a long URL, which may lack a scheme. It
+// mirrors how migration 0009 filled in urls.host for existing links.
+func urlHost(longUrl string) string {
+	host := longUrl
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+	for _, sep := range []string{"/", "?", "#"} {
+		host, _, _ = strings.Cut(host, sep)
+	}
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+	host, _, _ = strings.Cut(host, ":")
+	return strings.ToLower(host)
+}
+
+// normalizeTags lower cases and trims tags, dropping empty and repeated ones, so that
+// the same tags given in any order or case are stored the same way
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func validateDescription(settings LinkSettings) error {
+	if len(settings.Title) > maxTitleLen {
+		return fmt.Errorf("`title` must be at most %d characters", maxTitleLen)
+	}
+	if len(settings.Notes) > maxNotesLen {
+		return fmt.Errorf("`notes` must be at most %d characters", maxNotesLen)
+	}
+	if len(settings.Tags) > maxTags {
+		return fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range settings.Tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("tag %q must be up to 50 letters, digits, _ . : or -", tag)
+		}
+	}
+	return nil
+}
+
+// linkSummary is how a link is listed. The list is open to anyone, so it leaves out the
+// destinations that following a link wouldn't give away: those of protected links,
+// links that aren't active yet and links with a click limit.
+type linkSummary struct {
+	ShortUrl  string     `json:"shortUrl"`
+	LongUrl   string     `json:"longUrl,omitempty"`
+	Title     string     `json:"title,omitempty"`
+	Notes     string     `json:"notes,omitempty"`
+	Tags      []string   `json:"tags"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"` // Timestamp ids only
+	Clicks    uint64     `json:"clicks"`
+	Protected bool       `json:"protected"`
+}
+
+func newLinkSummary(link Link, shortUrl string) linkSummary {
+	tags := link.Settings.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	summary := linkSummary{
+		ShortUrl:  shortUrl,
+		LongUrl:   link.LongUrl,
+		Title:     link.Settings.Title,
+		Notes:     link.Settings.Notes,
+		Tags:      tags,
+		CreatedAt: knownTime(link.CreatedAt()),
+		Clicks:    link.Clicks,
+		Protected: link.Settings.PasswordHash != "",
+	}
+	if link.Withheld(time.Now()) {
+		summary.LongUrl = ""
+	}
+	return summary
+}
+
+// listFilter reads the filter for a page of links from the query
+func listFilter(c *gin.Context, codecs codecChain) (LinkFilter, error) {
+	filter := LinkFilter{
+		Tag:   strings.ToLower(strings.TrimSpace(c.Query("tag"))),
+		Host:  strings.ToLower(strings.TrimSpace(c.Query("host"))),
+		Limit: defaultListSize,
+	}
+	if c.Query("owner") != "" {
+		return LinkFilter{}, errors.New("links have no owner to filter by")
+	}
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxListSize {
+			return LinkFilter{}, fmt.Errorf("`limit` must be between 1 and %d", maxListSize)
+		}
+		filter.Limit = limit
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		ids := codecs.decode(cursor)
+		if len(ids) == 0 {
+			return LinkFilter{}, errors.New("malformed `cursor`")
+		}
+		filter.After = ids[0] // Cursors are always made by the first codec
+	}
+	for param, dst := range map[string]*UrlId{"createdAfter": &filter.From, "createdBefore": &filter.To} {
+		if raw := c.Query(param); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return LinkFilter{}, fmt.Errorf("`%s` must be an RFC 3339 time", param)
+			}
+			*dst = firstIdAt(t)
+		}
+	}
+	if filter.To != (UrlId{}) && filter.From == (UrlId{}) {
+		filter.From = UrlId{1} // The first timestamp id, as counter ids have no time to filter by
+	}
+	return filter, nil
+}
+
+// handleListLinks pages through links oldest first. A response with a nextCursor has
+// more links after it, to be asked for with that as the cursor.
+func (s *server) handleListLinks() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		filter, err := listFilter(c, s.app.codecs())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit := filter.Limit
+		filter.Limit++ // To tell whether there's another page
+		links, err := s.db.ListLinks(filter)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		resp := gin.H{}
+		if len(links) > limit {
+			links = links[:limit]
+			resp["nextCursor"] = s.app.shortCode(links[limit-1].Id)
+		}
+		summaries := make([]linkSummary, 0, len(links))
+		for _, link := range links {
+			summaries = append(summaries, newLinkSummary(link, s.app.shortCode(link.Id)))
+		}
+		resp["links"] = summaries
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+// handleDeleteLink deletes a link along with its clicks and history. Its short URL is
+// free to be handed out again, which only the importing of a link that keeps it does.
+func (s *server) handleDeleteLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		link, ok := s.findLinkJSON(c)
+		if !ok {
+			return
+		}
+		deleted, err := s.db.DeleteLink(link.Id)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !deleted { // By another request since it was looked up
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "shortUrl not known"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// forEachLink calls fn with every link in db in id order, reading a page at a time. It
+// stops at the first error from fn.
+func forEachLink(db UrlDB, fn func(link Link) error) error {
+	filter := LinkFilter{Limit: maxListSize}
+	for {
+		links, err := db.ListLinks(filter)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			if err = fn(link); err != nil {
+				return err
+			}
+		}
+		if len(links) < filter.Limit {
+			return nil
+		}
+		filter.After = links[len(links)-1].Id
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func listLinksForTest(t *testing.T, s *server, query string) []linkSummary {
+	t.Helper()
+	rec := doRequest(s, http.MethodGet, "/api/v1/links"+query, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from list, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Links []linkSummary `json:"links"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Links
+}
+
+func TestNormalizeTags(t *testing.T) {
+	got := normalizeTags([]string{" Launch", "press", "", "launch", "Q3"})
+	if want := []string{"launch", "press", "q3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestServer_ListLinks_ByTag(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com/a", "title": "Launch post", "notes": "For the blog", "tags": ["Launch", "blog"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	tagged := decodeBody(t, rec)["shortUrl"]
+	rec = doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=https://example.com/b&tags=launch&tags=press", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	shortenForTest(t, s, "https://example.com/c")
+
+	if links := listLinksForTest(t, s, ""); len(links) != 3 {
+		t.Errorf("Expected all 3 links without a filter, got %d", len(links))
+	}
+	if links := listLinksForTest(t, s, "?tag=LAUNCH"); len(links) != 2 {
+		t.Errorf("Expected 2 links tagged launch, got %d", len(links))
+	}
+	links := listLinksForTest(t, s, "?tag=blog")
+	if len(links) != 1 || links[0].ShortUrl != tagged || links[0].Title != "Launch post" || links[0].Notes != "For the blog" {
+		t.Errorf("Unexpected links tagged blog: %+v", links)
+	}
+}
+
+func TestServer_UpdateLink_Tags(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://example.com")
+
+	if rec := patchLink(s, shortUrl, `{"tags": ["docs"], "title": "Docs"}`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from update, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if links := listLinksForTest(t, s, "?tag=docs"); len(links) != 1 || links[0].Title != "Docs" {
+		t.Errorf("Expected the updated link under its new tag, got %+v", links)
+	}
+
+	patchLink(s, shortUrl, `{"tags": null}`, "")
+	if links := listLinksForTest(t, s, "?tag=docs"); len(links) != 0 {
+		t.Errorf("Expected no links once the tag was removed, got %d", len(links))
+	}
+}
+
+func TestServer_Shorten_DescriptionIsNotAConflict(t *testing.T) {
+	s := newTestServer(t)
+	first := shortenForTest(t, s, "https://example.com")
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "title": "Another title"}`)
+	if rec.Code != http.StatusOK || decodeBody(t, rec)["shortUrl"] != first {
+		t.Errorf("Expected the existing link, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServer_Shorten_InvalidTag(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "tags": ["no spaces"]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed tag, got %d", rec.Code)
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	tests := map[string]string{
+		"https://Docs.Example.com/v2?x=1":          "docs.example.com",
+		"www.google.com":                           "www.google.com",
+		"http://user:pw@example.com:8080/a":        "example.com",
+		"https://a.example.com?next=http://b.test": "a.example.com",
+		"https://example.com#top":                  "example.com",
+	}
+	for longUrl, want := range tests {
+		if got := urlHost(longUrl); got != want {
+			t.Errorf("urlHost(%q) = %q, want %q", longUrl, got, want)
+		}
+	}
+}
+
+func TestServer_ListLinks_Pages(t *testing.T) {
+	s := newTestServer(t)
+	var created []string
+	for _, longUrl := range []string{"https://a.example.com/1", "https://b.example.org/2", "https://a.example.com/3", "https://c.example.com/4", "https://a.example.com/5"} {
+		created = append(created, shortenForTest(t, s, longUrl))
+	}
+
+	var listed []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		rec := doRequest(s, http.MethodGet, "/api/v1/links?limit=2&cursor="+cursor, nil)
+		var body struct {
+			Links      []linkSummary `json:"links"`
+			NextCursor string        `json:"nextCursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range body.Links {
+			listed = append(listed, link.ShortUrl)
+		}
+		if body.NextCursor == "" {
+			break
+		}
+		cursor = body.NextCursor
+	}
+	if !reflect.DeepEqual(listed, created) {
+		t.Errorf("Expected every link once, in creation order, got %v", listed)
+	}
+
+	if links := listLinksForTest(t, s, "?host=a.example"); len(links) != 3 {
+		t.Errorf("Expected 3 links on a.example.com, got %d", len(links))
+	}
+}
+
+func TestServer_ListLinks_CreatedRange(t *testing.T) {
+	s := newTestServer(t)
+	old := Link{Id: UrlId{}, LongUrl: "https://example.com/old"}
+	_ = encodeID(&old.Id, uint32(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix()), 1)
+	if err := s.db.StoreLink(old); err != nil {
+		t.Fatal(err)
+	}
+	shortenForTest(t, s, "https://example.com/new")
+
+	if links := listLinksForTest(t, s, "?createdBefore=2021-01-01T00:00:00Z"); len(links) != 1 || links[0].LongUrl != old.LongUrl {
+		t.Errorf("Expected only the 2020 link, got %+v", links)
+	}
+	if links := listLinksForTest(t, s, "?createdAfter=2021-01-01T00:00:00Z"); len(links) != 1 || links[0].LongUrl == old.LongUrl {
+		t.Errorf("Expected only the new link, got %+v", links)
+	}
+}
+
+func TestServer_ListLinks_InvalidParams(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, query := range []string{"?limit=0", "?limit=5000", "?cursor=short", "?createdAfter=yesterday", "?owner=alice"} {
+		if rec := doRequest(s, http.MethodGet, "/api/v1/links"+query, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestServer_ListLinks_DestinationWithheld(t *testing.T) {
+	s := newTestServer(t)
+	open := shortenForTest(t, s, "https://example.com/open")
+	protected := shortenProtectedForTest(t, s)
+	shortenWindowForTest(t, s, "https://example.com/pending", `"notBefore": "`+time.Now().Add(time.Hour).UTC().Format(time.RFC3339)+`"`)
+	shortenWindowForTest(t, s, "https://example.com/limited", `"maxClicks": 1`)
+
+	links := listLinksForTest(t, s, "")
+	if len(links) != 4 {
+		t.Fatalf("Expected all 4 links to be listed, got %d", len(links))
+	}
+	for _, link := range links {
+		switch {
+		case link.ShortUrl == open:
+			if link.LongUrl != "https://example.com/open" || link.Protected {
+				t.Errorf("Expected the open link's destination, got %+v", link)
+			}
+		case link.LongUrl != "" || link.Protected != (link.ShortUrl == protected):
+			t.Errorf("Expected the destination to be withheld, got %+v", link)
+		}
+	}
+}
@@ -10,36 +10,23 @@ import (
 )
 
 func main() {
-	if err := run(); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
-	}
+	os.Exit(runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
 }
 
-func run() error {
-	db, dbTidy, err := buildSQLRepo("mysql", "root@tcp(db:3306)/urlshortener")
-	if err != nil {
-		return fmt.Errorf("failed to build SQL db: %w", err)
-	}
-	defer dbTidy()
-
+func runServer(cfg config, db UrlDB) error {
 	app := &URLShortenerApp{
 		urlRepo:     db,
-		idGenerator: newUniqueIDGenerator(),
+		idGenerator: newIDGenerator(cfg, db),
+		codes:       cfg.codecs,
 	}
 
-	s, err := newServer(gin.Default(), app, db)
+	s, err := newServer(gin.Default(), app, db, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-
 	// Only handle if err != http.ErrServerClosed
-	if err = http.ListenAndServe(":"+port, s); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if err = http.ListenAndServe(":"+cfg.port, s); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
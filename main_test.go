@@ -211,8 +211,8 @@ func TestDecodeBase62_ManualCalc3(t *testing.T) {
 func TestUniqueIDGeneratorImpl_GenerateUniqueID(t *testing.T) {
 	uidg := newUniqueIDGenerator()
 
-	id1 := uidg.GenerateUniqueID()
-	id2 := uidg.GenerateUniqueID()
+	id1, _ := uidg.GenerateUniqueID()
+	id2, _ := uidg.GenerateUniqueID()
 
 	if id1 == id2 {
 		// Incredibly small chance of failure, more of a sanity check that we're not
@@ -237,7 +237,7 @@ func TestUniqueIDGeneratorImpl_GenerateUniqueID_GuaranteeAllUniqueIn1Second(t *t
 			t.Logf("Generated %d unique ids in the test loop", len(set))
 			return
 		default:
-			id := uidg.GenerateUniqueID()
+			id, _ := uidg.GenerateUniqueID()
 			if _, exists := set[id]; exists {
 				t.Errorf("Generator produced the same id twice in a second")
 			}
@@ -251,7 +251,7 @@ func TestUniqueIDGeneratorImpl_GenerateUniqueID_Guarantee100000Unique(t *testing
 	uidg := newUniqueIDGenerator()
 
 	for i := 0; i < 100000; i++ {
-		id := uidg.GenerateUniqueID()
+		id, _ := uidg.GenerateUniqueID()
 		if _, exists := set[id]; exists {
 			t.Errorf("Generator produced the same id twice in 100000 iterations")
 		}
@@ -410,3 +410,73 @@ func TestEncodeID_TooLargeSeq(t *testing.T) {
 		t.Error("Should have errored on too large seq")
 	}
 }
+
+// racingUrlDb lets a competing request store the same long URL between the app's
+// lookup and its insert
+type racingUrlDb struct {
+	*InMemoryUrlDb
+	competitorId UrlId
+}
+
+func (r *racingUrlDb) StoreLink(link Link) error {
+	if err := r.InMemoryUrlDb.StoreURLRecord(r.competitorId, link.LongUrl); err != nil {
+		return err
+	}
+	return r.InMemoryUrlDb.StoreLink(link)
+}
+
+func TestURLShortenerApp_shorten_ConcurrentDuplicateUsesWinner(t *testing.T) {
+	db := &racingUrlDb{
+		InMemoryUrlDb: &InMemoryUrlDb{records: make([]InMemoryUrlDbRecord, 0)},
+		competitorId:  UrlId{1, 2, 3, 4, 5},
+	}
+	app := URLShortenerApp{
+		urlRepo:     db,
+		idGenerator: newUniqueIDGenerator(),
+	}
+
+	shortUrl, err := app.shorten("www.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shortUrl != encodeBase62(db.competitorId) {
+		t.Error("The app should return the id stored by the request that won the race")
+	}
+}
+
+func TestDecodeID_RoundTrip(t *testing.T) {
+	id := UrlId{}
+	err := encodeID(&id, 0x12345678, 0b000100100011010001010110)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seconds, seq := decodeID(id)
+	if seconds != 0x12345678 || seq != 0b000100100011010001010110 {
+		t.Error("decodeID should recover the seconds and seq passed to encodeID")
+	}
+}
+
+func TestIsShortUrl(t *testing.T) {
+	if !isShortUrl("EjEI4qOkHp") {
+		t.Error("A base62 code of the right length should be accepted")
+	}
+	if isShortUrl("EjEI4qOk") {
+		t.Error("A code of the wrong length should be rejected")
+	}
+	if isShortUrl("EjEI4qOk-p") {
+		t.Error("A code with characters outside of base62 should be rejected")
+	}
+}
+
+func TestUniqueIDGeneratorImpl_Running(t *testing.T) {
+	uidg := newUniqueIDGenerator()
+	if !uidg.Running() {
+		t.Error("A new generator should be running")
+	}
+
+	stalled := &UniqueIDGeneratorImpl{seconds: uint32(time.Now().Unix()) - 10}
+	if stalled.Running() {
+		t.Error("A generator whose sequence hasn't been reset recently should not be running")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+const migrationLockName = "urlshortener_schema_migrations"
+const mysqlErrNoSuchTable = 1146
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+// loadMigrations reads the migrations for backend, which must be numbered 1..n with
+// no gaps and have both an up and a down file
+func loadMigrations(fsys fs.FS, backend string) ([]migration, error) {
+	dir := path.Join("migrations", backend)
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		contents, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{version: version, name: match[2]}
+			byVersion[version] = m
+		}
+		if m.name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+		if match[3] == "up" {
+			m.up = string(contents)
+		} else {
+			m.down = string(contents)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %s is missing its up or down file", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// splitStatements breaks a migration file into statements, which end with a semicolon
+// at the end of a line. Lines starting with -- are comments.
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSuffix(strings.TrimSpace(current.String()), ";"))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
+
+type migrator struct {
+	db          *sql.DB
+	migrations  []migration
+	lockTimeout time.Duration
+	out         io.Writer // Progress and dry-run output
+}
+
+func newMySQLMigrator(db *sql.DB, out io.Writer) (*migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "mysql")
+	if err != nil {
+		return nil, err
+	}
+	return &migrator{db: db, migrations: migrations, lockTimeout: 30 * time.Second, out: out}, nil
+}
+
+func (m *migrator) latest() int {
+	return len(m.migrations)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Conn
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// schemaVersion returns the highest applied migration, 0 if none have been
+func schemaVersion(ctx context.Context, q queryRower) (int, error) {
+	var version sql.NullInt64
+	err := q.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlErrNoSuchTable {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// checkCurrent returns an error if migrations are pending
+func (m *migrator) checkCurrent(ctx context.Context) error {
+	version, err := schemaVersion(ctx, m.db)
+	if err != nil {
+		return err
+	}
+	if version < m.latest() {
+		return fmt.Errorf("database schema is at version %d but %d is required, run `urlshortener migrate up`", version, m.latest())
+	}
+	return nil
+}
+
+func (m *migrator) status(ctx context.Context) error {
+	version, err := schemaVersion(ctx, m.db)
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		state := "pending"
+		if mig.version <= version {
+			state = "applied"
+		}
+		_, _ = fmt.Fprintf(m.out, "%-8s %s\n", state, mig)
+	}
+	_, _ = fmt.Fprintf(m.out, "schema version %d of %d\n", version, m.latest())
+	return nil
+}
+
+// up applies pending migrations up to and including target
+func (m *migrator) up(ctx context.Context, target int, dryRun bool) error {
+	if target > m.latest() {
+		return fmt.Errorf("no migration %d, latest is %d", target, m.latest())
+	}
+	return m.locked(ctx, dryRun, func(conn *sql.Conn, version int) error {
+		for _, mig := range m.migrations {
+			if mig.version <= version || mig.version > target {
+				continue
+			}
+			err := m.apply(ctx, conn, mig, "up", dryRun, func() error {
+				_, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", mig.version, mig.name)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// down reverts the most recently applied migrations, steps of them
+func (m *migrator) down(ctx context.Context, steps int, dryRun bool) error {
+	return m.locked(ctx, dryRun, func(conn *sql.Conn, version int) error {
+		if version > m.latest() {
+			return fmt.Errorf("database schema is at version %d, newer than this binary's %d", version, m.latest())
+		}
+		for i := 0; i < steps && version-i > 0; i++ {
+			mig := m.migrations[version-i-1]
+			err := m.apply(ctx, conn, mig, "down", dryRun, func() error {
+				_, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.version)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *migrator) apply(ctx context.Context, conn *sql.Conn, mig migration, direction string, dryRun bool, record func() error) error {
+	script := mig.up
+	if direction == "down" {
+		script = mig.down
+	}
+
+	if dryRun {
+		_, _ = fmt.Fprintf(m.out, "-- %s (%s)\n", mig, direction)
+		for _, stmt := range splitStatements(script) {
+			_, _ = fmt.Fprintf(m.out, "%s;\n", stmt)
+		}
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(m.out, "applying %s (%s)\n", mig, direction)
+	for _, stmt := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %s (%s) failed: %w", mig, direction, err)
+		}
+	}
+	return record()
+}
+
+// locked runs fn holding a MySQL named lock, so that instances starting together
+// don't apply the same migrations concurrently. fn receives the version read after
+// the lock was acquired.
+func (m *migrator) locked(ctx context.Context, dryRun bool, fn func(conn *sql.Conn, version int) error) error {
+	conn, err := m.db.Conn(ctx) // Named locks belong to a connection, so hold on to one
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var acquired sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, int(m.lockTimeout.Seconds())).Scan(&acquired)
+	if err != nil {
+		return err
+	}
+	if acquired.Int64 != 1 {
+		return fmt.Errorf("timed out waiting for another instance to finish migrating")
+	}
+	defer conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", migrationLockName)
+
+	if !dryRun {
+		_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT UNSIGNED NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (version)
+		)`)
+		if err != nil {
+			return err
+		}
+	}
+
+	version, err := schemaVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, version)
+}
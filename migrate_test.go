@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations_Embedded(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "mysql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 || migrations[0].name != "create_urls" {
+		t.Error("Embedded migrations should start with creating the urls table")
+	}
+}
+
+func TestLoadMigrations_Ordered(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/test/0002_second.up.sql":   {Data: []byte("up2")},
+		"migrations/test/0002_second.down.sql": {Data: []byte("down2")},
+		"migrations/test/0001_first.up.sql":    {Data: []byte("up1")},
+		"migrations/test/0001_first.down.sql":  {Data: []byte("down1")},
+	}
+	migrations, err := loadMigrations(fsys, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []migration{{1, "first", "up1", "down1"}, {2, "second", "up2", "down2"}}
+	if !reflect.DeepEqual(migrations, expected) {
+		t.Errorf("Unexpected migrations %v", migrations)
+	}
+}
+
+func TestLoadMigrations_Gap(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/test/0001_first.up.sql":   {Data: []byte("up1")},
+		"migrations/test/0001_first.down.sql": {Data: []byte("down1")},
+		"migrations/test/0003_third.up.sql":   {Data: []byte("up3")},
+		"migrations/test/0003_third.down.sql": {Data: []byte("down3")},
+	}
+	if _, err := loadMigrations(fsys, "test"); err == nil {
+		t.Error("A gap in migration versions should be rejected")
+	}
+}
+
+func TestLoadMigrations_MissingDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/test/0001_first.up.sql": {Data: []byte("up1")},
+	}
+	if _, err := loadMigrations(fsys, "test"); err == nil {
+		t.Error("A migration without a down file should be rejected")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := "-- comment\nCREATE TABLE a (\n  id INT\n);\n\nALTER TABLE a ADD COLUMN b INT;\n"
+	expected := []string{"CREATE TABLE a (\n  id INT\n)", "ALTER TABLE a ADD COLUMN b INT"}
+	if actual := splitStatements(script); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Unexpected statements %q", actual)
+	}
+}
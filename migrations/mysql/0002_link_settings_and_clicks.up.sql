@@ -0,0 +1,2 @@
+ALTER TABLE urls ADD COLUMN settings BLOB NULL;
+ALTER TABLE urls ADD COLUMN clicks BIGINT UNSIGNED NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- NULL for links without a click limit
+ALTER TABLE urls ADD COLUMN clicks_remaining BIGINT UNSIGNED NULL;
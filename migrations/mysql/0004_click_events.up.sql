@@ -0,0 +1,9 @@
+-- One row per attributed click, the total stays in urls.clicks
+CREATE TABLE IF NOT EXISTS click_events (
+     event_id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,
+     id BINARY(7) NOT NULL,
+     clicked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+     variant VARCHAR(255) NULL,
+     PRIMARY KEY (event_id),
+     INDEX (id, variant)
+) ENGINE = RocksDB DEFAULT COLLATE = ascii_bin;
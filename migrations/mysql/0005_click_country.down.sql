@@ -0,0 +1,2 @@
+ALTER TABLE click_events DROP INDEX id_country;
+ALTER TABLE click_events DROP COLUMN country;
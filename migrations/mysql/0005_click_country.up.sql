@@ -0,0 +1,2 @@
+ALTER TABLE click_events ADD COLUMN country CHAR(2) NULL;
+ALTER TABLE click_events ADD INDEX id_country (id, country);
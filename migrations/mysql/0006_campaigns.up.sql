@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS campaigns (
+     name VARCHAR(64) NOT NULL,
+     utm_source VARCHAR(255) NOT NULL DEFAULT '',
+     utm_medium VARCHAR(255) NOT NULL DEFAULT '',
+     utm_campaign VARCHAR(255) NOT NULL DEFAULT '',
+     utm_term VARCHAR(255) NOT NULL DEFAULT '',
+     utm_content VARCHAR(255) NOT NULL DEFAULT '',
+     PRIMARY KEY (name)
+) ENGINE = RocksDB DEFAULT COLLATE = utf8mb4_bin;
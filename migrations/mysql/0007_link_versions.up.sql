@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS link_versions (
+     id BINARY(7) NOT NULL,
+     version INT UNSIGNED NOT NULL,
+     actor VARCHAR(255) NOT NULL DEFAULT '',
+     changed_at TIMESTAMP NOT NULL,
+     long_url VARCHAR(255) NOT NULL,
+     settings BLOB NULL,
+     diff BLOB NULL,
+     PRIMARY KEY (id, version)
+) ENGINE = RocksDB DEFAULT COLLATE = ascii_bin;
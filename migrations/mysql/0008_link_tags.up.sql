@@ -0,0 +1,6 @@
+-- Tags are stored in urls.settings, this indexes links by them
+CREATE TABLE IF NOT EXISTS link_tags (
+     tag VARCHAR(50) NOT NULL,
+     id BINARY(7) NOT NULL,
+     PRIMARY KEY (tag, id)
+) ENGINE = RocksDB DEFAULT COLLATE = ascii_bin;
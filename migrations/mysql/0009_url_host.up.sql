@@ -0,0 +1,6 @@
+-- The destination's host, lower cased, for searching links by host. Kept in step with
+-- urlHost in links.go, which fills it in for new links.
+ALTER TABLE urls ADD COLUMN host VARCHAR(255) NOT NULL DEFAULT '';
+UPDATE urls SET host = LOWER(SUBSTRING_INDEX(SUBSTRING_INDEX(SUBSTRING_INDEX(SUBSTRING_INDEX(SUBSTRING_INDEX(
+     IF(LOCATE('://', long_url) > 0, SUBSTRING(long_url, LOCATE('://', long_url) + 3), long_url),
+     '/', 1), '?', 1), '#', 1), '@', -1), ':', 1));
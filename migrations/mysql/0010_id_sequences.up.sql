@@ -0,0 +1,9 @@
+-- Sequences that counter ids are reserved from, a block at a time. The links sequence
+-- starts at 62^3, as firstCounter in counter.go does, so compact codes start at 4
+-- characters.
+CREATE TABLE IF NOT EXISTS id_sequences (
+     name VARCHAR(64) NOT NULL,
+     next_id BIGINT UNSIGNED NOT NULL,
+     PRIMARY KEY (name)
+) ENGINE = RocksDB DEFAULT COLLATE = ascii_bin;
+INSERT INTO id_sequences (name, next_id) VALUES ('links', 238328);
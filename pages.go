@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"html/template"
+	"time"
+)
+
+// pages are the HTML documents served to people following short links
+var pages = template.Must(template.New("pages").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("January 2, 2006 at 15:04 MST") },
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 40rem; margin: 4rem auto; padding: 0 1rem; color: #222; }
+.destination { word-break: break-all; font-size: 1.1rem; padding: 1rem; background: #f4f4f4; border-radius: 4px; }
+.meta { color: #666; }
+.error { color: #c01c28; }
+a.button { display: inline-block; padding: .6rem 1.2rem; background: #1a5fb4; color: #fff; border-radius: 4px; text-decoration: none; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "foot"}}</body>
+</html>
+{{end}}
+
+{{define "preview"}}{{template "head" "Link preview"}}
+<h1>{{if .Interstitial}}You are leaving for another site{{else}}Link preview{{end}}</h1>
+{{if .Protected}}
+<p><code>{{.PublicURL}}</code> is password protected, so its destination is hidden.</p>
+{{else if .Limited}}
+<p><code>{{.PublicURL}}</code> can only be followed a limited number of times, so its destination is only shown by following it.</p>
+{{else}}
+<p><code>{{.PublicURL}}</code> leads to:</p>
+<p class="destination">{{.LongUrl}}</p>
+{{end}}
+<p class="meta">{{if not .CreatedAt.IsZero}}Created {{date .CreatedAt}} &middot; {{end}}followed {{.Clicks}} time{{if ne .Clicks 1}}s{{end}}</p>
+{{if .Protected}}
+<p><a class="button" href="{{.PublicURL}}">Enter password</a></p>
+{{else if .Limited}}
+<p><a class="button" href="{{.PublicURL}}">Continue to destination</a></p>
+{{else}}
+<p><a class="button" href="{{.LongUrl}}" rel="noreferrer noopener">Continue to destination</a></p>
+{{end}}
+{{template "foot"}}{{end}}
+
+{{define "password"}}{{template "head" "Password required"}}
+<h1>Password required</h1>
+<p><code>{{.PublicURL}}</code> is password protected.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<p><input type="password" name="password" autocomplete="current-password" required autofocus></p>
+<p><button type="submit">Continue</button></p>
+</form>
+{{template "foot"}}{{end}}
+
+{{define "gone"}}{{template "head" "Link no longer available"}}
+<h1>Link no longer available</h1>
+<p>This link has expired and no longer leads anywhere.</p>
+{{template "foot"}}{{end}}
+
+{{define "pending"}}{{template "head" "Link not yet available"}}
+<h1>Link not yet available</h1>
+<p>This link becomes available on {{date .NotBefore}}. Check back then.</p>
+{{template "foot"}}{{end}}
+
+{{define "not-found"}}{{template "head" "Link not found"}}
+<h1>Link not found</h1>
+<p>There is no link at this address. Check that it was copied correctly.</p>
+{{template "foot"}}{{end}}
+`))
+
+type previewPage struct {
+	PublicURL    string
+	LongUrl      string
+	CreatedAt    time.Time
+	Clicks       uint64
+	Interstitial bool // Shown in place of a redirect, rather than asked for
+	Protected    bool // Password protected, so LongUrl is withheld
+	Limited      bool // Has a click limit, so LongUrl is withheld unless the click was counted
+}
+
+func renderPage(c *gin.Context, status int, name string, data interface{}) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Header("Cache-Control", "no-store")
+	c.Status(status)
+	if err := pages.ExecuteTemplate(c.Writer, name, data); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+type pendingPage struct {
+	NotBefore time.Time
+}
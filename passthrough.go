@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// passThrough adds what a visitor appended to a short URL to its destination, so that
+// /code/guide/intro?lang=de on a link to https://docs.example.com/v2?src=short leads to
+// https://docs.example.com/v2/guide/intro?lang=de&src=short.
+//
+// The extra path is cleaned first, so it can't climb above the destination's path.
+// Query parameters the destination already sets win over the visitor's, so that a link
+// can't be made to send a different value than its owner chose. All other parameters
+// are added, keeping repeated values.
+func passThrough(destination string, extraPath string, query url.Values) (string, error) {
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+
+	if extra := strings.TrimPrefix(path.Clean("/"+extraPath), "/"); extra != "" {
+		if strings.HasSuffix(extraPath, "/") { // Clean drops it, but it matters to many sites
+			extra += "/"
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + extra
+		u.RawPath = ""
+	}
+
+	return addQuery(u.String(), query)
+}
+
+// addQuery adds the parameters in query that destination doesn't already have
+func addQuery(destination string, query url.Values) (string, error) {
+	if len(query) == 0 {
+		return destination, nil
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	merged := u.Query()
+	for key, values := range query {
+		if _, fixed := merged[key]; !fixed {
+			merged[key] = values
+		}
+	}
+	u.RawQuery = merged.Encode()
+	return u.String(), nil
+}
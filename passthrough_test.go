@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPassThrough(t *testing.T) {
+	tests := []struct {
+		destination string
+		extraPath   string
+		query       string
+		want        string
+	}{
+		{"https://docs.example.com/v2", "/guide/intro", "", "https://docs.example.com/v2/guide/intro"},
+		{"https://docs.example.com/v2/", "/guide/", "", "https://docs.example.com/v2/guide/"},
+		{"https://docs.example.com", "/guide", "", "https://docs.example.com/guide"},
+		{"https://docs.example.com/v2", "/../../etc/passwd", "", "https://docs.example.com/v2/etc/passwd"},
+		{"https://docs.example.com/v2", "", "lang=de", "https://docs.example.com/v2?lang=de"},
+		{"https://docs.example.com/v2?src=short", "/a", "lang=de&src=spoofed", "https://docs.example.com/v2/a?lang=de&src=short"},
+		{"https://docs.example.com/v2#top", "/a", "tag=x&tag=y", "https://docs.example.com/v2/a?tag=x&tag=y#top"},
+		{"https://docs.example.com/v2", "/a b", "", "https://docs.example.com/v2/a%20b"},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		got, err := passThrough(test.destination, test.extraPath, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("passThrough(%q, %q, %q) = %q, want %q", test.destination, test.extraPath, test.query, got, test.want)
+		}
+	}
+}
+
+func TestServer_Follow_Passthrough(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://docs.example.com/v2?src=short", "passthrough": true}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl+"/guide/intro?lang=de", nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected 302, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Location"); got != "https://docs.example.com/v2/guide/intro?lang=de&src=short" {
+		t.Errorf("Expected the path and query to be passed on, got %s", got)
+	}
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl+"/preview", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Link preview") {
+		t.Errorf("The preview path should still show the preview, got %d", rec.Code)
+	}
+}
+
+func TestServer_Follow_ExtraPathWithoutPassthrough(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://docs.example.com")
+
+	rec := doRequest(s, http.MethodGet, "/"+shortUrl+"/guide", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a path below a link without passthrough, got %d", rec.Code)
+	}
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl+"?lang=de", nil)
+	if got := rec.Header().Get("Location"); got != "https://docs.example.com" {
+		t.Errorf("The query should be ignored without passthrough, got %s", got)
+	}
+}
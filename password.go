@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	unlockCookiePrefix = "lk_"
+	maxUnlockAttempts  = 5
+	unlockAttemptReset = 15 * time.Minute
+	maxPasswordLen     = 72 // In bytes, as bcrypt hashes no more
+)
+
+func validatePassword(password string) error {
+	if len(password) > maxPasswordLen {
+		return fmt.Errorf("`password` must be at most %d bytes", maxPasswordLen)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func checkPassword(hash string, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// unlockThrottle limits failed password attempts per link, so that a protected link
+// can't be brute forced. Attempts are counted per instance.
+type unlockThrottle struct {
+	failures map[string]*unlockFailures
+	lock     sync.Mutex
+	now      func() time.Time
+}
+
+type unlockFailures struct {
+	count int
+	since time.Time
+}
+
+func newUnlockThrottle() *unlockThrottle {
+	return &unlockThrottle{failures: make(map[string]*unlockFailures), now: time.Now}
+}
+
+// attempt reports whether shortUrl may be tried again, and if not, for how long. An
+// allowed attempt is counted as a failure straight away, before the password is checked,
+// so that concurrent attempts can't all get in before any has failed. succeed clears it.
+func (ut *unlockThrottle) attempt(shortUrl string) (bool, time.Duration) {
+	ut.lock.Lock()
+	defer ut.lock.Unlock()
+
+	f, exists := ut.failures[shortUrl]
+	if exists && ut.now().Sub(f.since) >= unlockAttemptReset {
+		exists = false
+	}
+	if !exists {
+		f = &unlockFailures{since: ut.now()}
+		ut.failures[shortUrl] = f
+	}
+	if f.count >= maxUnlockAttempts {
+		return false, unlockAttemptReset - ut.now().Sub(f.since)
+	}
+	f.count++
+	return true, 0
+}
+
+func (ut *unlockThrottle) succeed(shortUrl string) {
+	ut.lock.Lock()
+	defer ut.lock.Unlock()
+	delete(ut.failures, shortUrl)
+}
+
+// unlockSignature binds an unlock cookie to the link, its expiry and its current
+// password, so that changing the password revokes cookies issued for the old one
+func (s *server) unlockSignature(link Link, expires int64) string {
+	mac := hmac.New(sha256.New, s.cookieSecret)
+	_, _ = fmt.Fprintf(mac, "%s|%d|%s", encodeBase62(link.Id), expires, link.Settings.PasswordHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *server) setUnlockCookie(c *gin.Context, link Link) {
+	expires := time.Now().Add(s.unlockTTL).Unix()
+	value := strconv.FormatInt(expires, 10) + "." + s.unlockSignature(link, expires)
+	secure := strings.HasPrefix(s.baseURL, "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(unlockCookiePrefix+encodeBase62(link.Id), value, int(s.unlockTTL.Seconds()), cookiePath(c), "", secure, true)
+}
+
+func (s *server) hasUnlockCookie(c *gin.Context, link Link) bool {
+	value, err := c.Cookie(unlockCookiePrefix + encodeBase62(link.Id))
+	if err != nil {
+		return false
+	}
+	rawExpires, signature, found := strings.Cut(value, ".")
+	if !found {
+		return false
+	}
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(s.unlockSignature(link, expires)))
+}
+
+type passwordPage struct {
+	PublicURL string
+	Action    string // Where the form posts to, the page it was served on
+	Error     string
+}
+
+// handleUnlock checks the password posted from the form served for a protected link
+func (s *server) handleUnlock() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shortUrl := c.Param("code")
+		link, ok := s.findLink(c, shortUrl)
+		if !ok {
+			return
+		}
+		if link.Settings.PasswordHash == "" {
+			c.Redirect(http.StatusSeeOther, s.publicURL(shortUrl))
+			return
+		}
+		if !s.available(c, link) {
+			return
+		}
+
+		page := passwordPage{PublicURL: s.publicURL(shortUrl), Action: c.Request.URL.RequestURI()}
+		attempts := encodeBase62(link.Id) // Not shortUrl, which several codes can stand for
+		if ok, wait := s.unlocks.attempt(attempts); !ok {
+			c.Header("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			page.Error = "Too many incorrect attempts. Try again later."
+			renderPage(c, http.StatusTooManyRequests, "password", page)
+			return
+		}
+		if !checkPassword(link.Settings.PasswordHash, c.PostForm("password")) {
+			page.Error = "Incorrect password."
+			renderPage(c, http.StatusUnauthorized, "password", page)
+			return
+		}
+
+		s.unlocks.succeed(attempts)
+		s.setUnlockCookie(c, link)
+		s.deliver(c, link, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func shortenProtectedForTest(t *testing.T, s *server) string {
+	t.Helper()
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://intranet.example.com/doc", "password": "hunter2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d", rec.Code)
+	}
+	return decodeBody(t, rec)["shortUrl"]
+}
+
+func postPassword(s *server, shortUrl string, password string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/"+shortUrl, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_Follow_PasswordForm(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	rec := doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "intranet.example.com") {
+		t.Error("The password form should not reveal the destination")
+	}
+	if !strings.Contains(rec.Body.String(), `type="password"`) {
+		t.Error("Expected a password form")
+	}
+}
+
+func TestServer_Unlock(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	if rec := postPassword(s, shortUrl, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong password, got %d", rec.Code)
+	}
+
+	rec := postPassword(s, shortUrl, "hunter2")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "https://intranet.example.com/doc" {
+		t.Fatalf("Expected a redirect to the destination, got %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatal("Expected an HttpOnly unlock cookie")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+shortUrl, nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound {
+		t.Errorf("The unlock cookie should skip the password form, got %d", rec.Code)
+	}
+}
+
+func TestServer_Unlock_ForgedCookie(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	req := httptest.NewRequest(http.MethodGet, "/"+shortUrl, nil)
+	req.AddCookie(&http.Cookie{Name: unlockCookiePrefix + shortUrl, Value: "99999999999.deadbeef"})
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("A forged cookie should not unlock the link, got %d", rec.Code)
+	}
+}
+
+func TestServer_Unlock_Throttled(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	for i := 0; i < maxUnlockAttempts; i++ {
+		postPassword(s, shortUrl, "wrong")
+	}
+	rec := postPassword(s, shortUrl, "hunter2")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 after too many failures, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+}
+
+func TestServer_Redirect_PasswordProtected(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+shortUrl, nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403, got %d", rec.Code)
+	}
+}
+
+func TestServer_Preview_PasswordProtected(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	rec := doRequest(s, http.MethodGet, "/"+shortUrl+"+", nil)
+	if strings.Contains(rec.Body.String(), "intranet.example.com") {
+		t.Error("The preview should not reveal the destination of a protected link")
+	}
+}
+
+func TestServer_Shorten_SamePasswordIsNotAConflict(t *testing.T) {
+	s := newTestServer(t)
+	first := shortenProtectedForTest(t, s)
+
+	if second := shortenProtectedForTest(t, s); first != second {
+		t.Error("Shortening again with the same password should return the same link")
+	}
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://intranet.example.com/doc", "password": "other"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a different password, got %d", rec.Code)
+	}
+}
+
+func TestUnlockThrottle_Resets(t *testing.T) {
+	now := time.Now()
+	ut := newUnlockThrottle()
+	ut.now = func() time.Time { return now }
+
+	for i := 0; i < maxUnlockAttempts; i++ {
+		if ok, _ := ut.attempt("code"); !ok {
+			t.Fatalf("Attempt %d should be allowed", i+1)
+		}
+	}
+	if ok, _ := ut.attempt("code"); ok {
+		t.Fatal("Attempts should be blocked after too many failures")
+	}
+
+	now = now.Add(unlockAttemptReset)
+	if ok, _ := ut.attempt("code"); !ok {
+		t.Error("Attempts should be allowed again once the window has passed")
+	}
+}
+
+func TestServer_Unlock_ConcurrentAttempts(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenProtectedForTest(t, s)
+
+	codes := make(chan int, 3*maxUnlockAttempts)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postPassword(s, shortUrl, "wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	checked := 0
+	for code := range codes {
+		if code == http.StatusUnauthorized {
+			checked++
+		}
+	}
+	if checked != maxUnlockAttempts {
+		t.Errorf("Expected %d passwords to be checked, got %d", maxUnlockAttempts, checked)
+	}
+}
+
+func TestServer_Shorten_PasswordTooLong(t *testing.T) {
+	s := newTestServer(t)
+	password := strings.Repeat("x", maxPasswordLen+1)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://intranet.example.com/doc", "password": "`+password+`"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a password bcrypt can't hash, got %d", rec.Code)
+	}
+
+	shortUrl := shortenForTest(t, s, "https://example.com")
+	if rec = patchLink(s, shortUrl, `{"password": "`+password+`"}`, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 from PATCH, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultQRSize   = 256
+	minQRSize       = 64
+	maxQRSize       = 2048
+	defaultQRMargin = 4 // Modules, the quiet zone the QR spec asks for
+	maxQRMargin     = 16
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+type qrOptions struct {
+	format string // png or svg
+	size   int    // Pixels per side
+	margin int    // Modules of background around the code
+	level  string
+	fg     color.RGBA
+	bg     color.RGBA
+}
+
+// key identifies the rendered output, which only depends on the content and options
+func (o qrOptions) key(content string) string {
+	return fmt.Sprintf("%s|%s|%d|%d|%s|%s|%s", content, o.format, o.size, o.margin, o.level, hexColor(o.fg), hexColor(o.bg))
+}
+
+func parseQROptions(c *gin.Context) (qrOptions, error) {
+	opts := qrOptions{
+		format: strings.ToLower(c.DefaultQuery("format", "png")),
+		level:  strings.ToUpper(c.DefaultQuery("level", "M")),
+	}
+	var err error
+
+	if opts.format != "png" && opts.format != "svg" {
+		return qrOptions{}, fmt.Errorf("param `format` must be png or svg")
+	}
+	if opts.size, err = intParam(c, "size", defaultQRSize, minQRSize, maxQRSize); err != nil {
+		return qrOptions{}, err
+	}
+	if opts.margin, err = intParam(c, "margin", defaultQRMargin, 0, maxQRMargin); err != nil {
+		return qrOptions{}, err
+	}
+	if _, ok := qrLevels[opts.level]; !ok {
+		return qrOptions{}, fmt.Errorf("param `level` must be one of L, M, Q or H")
+	}
+	if opts.fg, err = parseHexColor(c.DefaultQuery("fg", "000000")); err != nil {
+		return qrOptions{}, fmt.Errorf("param `fg`: %w", err)
+	}
+	if opts.bg, err = parseHexColor(c.DefaultQuery("bg", "ffffff")); err != nil {
+		return qrOptions{}, fmt.Errorf("param `bg`: %w", err)
+	}
+
+	return opts, nil
+}
+
+func intParam(c *gin.Context, name string, def int, min int, max int) (int, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil || v < min || v > max {
+		return 0, fmt.Errorf("param `%s` must be an integer between %d and %d", name, min, max)
+	}
+	return v, nil
+}
+
+// parseHexColor accepts RRGGBB or RRGGBBAA, with or without a leading #
+func parseHexColor(s string) (color.RGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 && len(s) != 8 {
+		return color.RGBA{}, fmt.Errorf("color must be RRGGBB or RRGGBBAA hex")
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("color must be RRGGBB or RRGGBBAA hex")
+	}
+	c := color.RGBA{R: b[0], G: b[1], B: b[2], A: 0xff}
+	if len(b) == 4 {
+		c.A = b[3]
+	}
+	return c, nil
+}
+
+func hexColor(c color.RGBA) string {
+	return hex.EncodeToString([]byte{c.R, c.G, c.B, c.A})
+}
+
+// renderQR encodes content as a QR code, returning the image and its content type
+func renderQR(content string, opts qrOptions) ([]byte, string, error) {
+	q, err := qrcode.New(content, qrLevels[opts.level])
+	if err != nil {
+		return nil, "", err
+	}
+	q.DisableBorder = true // The margin is drawn here so that it can be configured
+	modules := q.Bitmap()
+
+	if opts.format == "svg" {
+		return renderQRSVG(modules, opts), "image/svg+xml", nil
+	}
+	out, err := renderQRPNG(modules, opts)
+	return out, "image/png", err
+}
+
+// qrLayout returns the pixels per module and the offset that centres the code,
+// margin included, in an image of opts.size pixels
+func qrLayout(n int, opts qrOptions) (scale int, offset int) {
+	total := n + 2*opts.margin
+	scale = opts.size / total
+	if scale < 1 {
+		scale = 1
+	}
+	offset = (opts.size-scale*total)/2 + opts.margin*scale
+	return scale, offset
+}
+
+func renderQRPNG(modules [][]bool, opts qrOptions) ([]byte, error) {
+	scale, offset := qrLayout(len(modules), opts)
+	img := image.NewPaletted(image.Rect(0, 0, opts.size, opts.size), color.Palette{opts.bg, opts.fg})
+	for y, row := range modules {
+		for x, on := range row {
+			if !on {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(offset+x*scale+dx, offset+y*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderQRSVG(modules [][]bool, opts qrOptions) []byte {
+	scale, offset := qrLayout(len(modules), opts)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, opts.size, opts.size, opts.size, opts.size)
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#%02x%02x%02x" fill-opacity="%.3f"/>`, opts.bg.R, opts.bg.G, opts.bg.B, float64(opts.bg.A)/0xff)
+	fmt.Fprintf(&buf, `<path fill="#%02x%02x%02x" fill-opacity="%.3f" d="`, opts.fg.R, opts.fg.G, opts.fg.B, float64(opts.fg.A)/0xff)
+	for y, row := range modules {
+		for x, on := range row {
+			if on {
+				fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz", offset+x*scale, offset+y*scale, scale, scale, scale)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+func (s *server) handleQR() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		shortUrl := c.Param("code")
+		opts, err := parseQROptions(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		longUrl, err := s.app.redirect(shortUrl)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
+			return
+		}
+		if longUrl == "" {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "shortUrl not known"})
+			return
+		}
+
+		// The image is deterministic, so its ETag can be computed without rendering it
+		content := s.publicURL(shortUrl)
+		sum := sha256.Sum256([]byte(opts.key(content)))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", "public, max-age=86400")
+		if c.GetHeader("If-None-Match") == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		out, contentType, err := renderQR(content, opts)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "failed to render QR code"})
+			return
+		}
+		c.Data(http.StatusOK, contentType, out)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer_QR_PNG(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "www.google.com")
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/qr?size=300", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("Unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+	img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 300 || img.Bounds().Dy() != 300 {
+		t.Errorf("Expected a 300x300 image, got %v", img.Bounds())
+	}
+}
+
+func TestServer_QR_SVG(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "www.google.com")
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/qr?format=svg&fg=ff0000&bg=%23ffffff00", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "<svg") || !strings.Contains(body, `fill="#ff0000"`) {
+		t.Error("Expected an SVG drawn in the requested foreground color")
+	}
+}
+
+func TestServer_QR_ETag(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "www.google.com")
+	target := "/api/v1/links/" + shortUrl + "/qr"
+
+	etag := doRequest(s, http.MethodGet, target, nil).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+	rec := doRequest(s, http.MethodGet, target, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", rec.Code)
+	}
+	if other := doRequest(s, http.MethodGet, target+"?level=H", nil).Header().Get("ETag"); other == etag {
+		t.Error("Different options should produce a different ETag")
+	}
+}
+
+func TestServer_QR_UnknownCode(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/links/"+encodeBase62(UrlId{1, 2, 3})+"/qr", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+}
+
+func TestServer_QR_InvalidOptions(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "www.google.com")
+
+	for _, query := range []string{"format=gif", "size=10", "margin=-1", "level=X", "fg=red"} {
+		rec := doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/qr?"+query, nil)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestServer_Follow(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenForTest(t, s, "https://www.google.com")
+
+	rec := doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected 302, got %d", rec.Code)
+	}
+	if rec.Header().Get("Location") != "https://www.google.com" {
+		t.Errorf("Unexpected Location %q", rec.Header().Get("Location"))
+	}
+
+	if rec := doRequest(s, http.MethodGet, "/"+encodeBase62(UrlId{1, 2, 3}), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown code, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	variantCookiePrefix = "lv_"
+	variantCookieTTL    = 30 * 24 * time.Hour
+	maxDestinations     = 20
+)
+
+// Destination is one of the pages a rotating link splits its visits between
+type Destination struct {
+	URL    string `json:"url"`
+	Weight uint32 `json:"weight,omitempty"` // Relative share of visits, 0 counts as 1
+}
+
+func (d Destination) weight() uint32 {
+	if d.Weight == 0 {
+		return 1
+	}
+	return d.Weight
+}
+
+// key identifies the destination in a sticky cookie without putting its URL there
+func (d Destination) key() string {
+	sum := sha256.Sum256([]byte(d.URL))
+	return hex.EncodeToString(sum[:8])
+}
+
+func validateDestinations(destinations []Destination) error {
+	if len(destinations) > maxDestinations {
+		return fmt.Errorf("at most %d destinations are allowed", maxDestinations)
+	}
+	seen := make(map[string]bool, len(destinations))
+	for _, d := range destinations {
+		if d.URL == "" {
+			return fmt.Errorf("every destination needs a `url`")
+		}
+		if seen[d.URL] {
+			return fmt.Errorf("destination %s is listed twice", d.URL)
+		}
+		seen[d.URL] = true
+	}
+	return nil
+}
+
+// variantPicker chooses destinations at random in proportion to their weights
+type variantPicker struct {
+	rnd  *rand.Rand
+	lock sync.Mutex // rand.Rand isn't safe for concurrent use
+}
+
+func newVariantPicker() *variantPicker {
+	return &variantPicker{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (vp *variantPicker) pick(destinations []Destination) Destination {
+	var total int64
+	for _, d := range destinations {
+		total += int64(d.weight())
+	}
+
+	vp.lock.Lock()
+	n := vp.rnd.Int63n(total)
+	vp.lock.Unlock()
+
+	for _, d := range destinations {
+		n -= int64(d.weight())
+		if n < 0 {
+			return d
+		}
+	}
+	return destinations[len(destinations)-1] // Unreachable
+}
+
+// destination decides where this visit to link goes, and which variant to attribute
+// it to when the link has several destinations
+func (s *server) destination(c *gin.Context, link Link) (string, Click) {
+	v := s.visitor(c)
+	if len(link.Settings.Routes) > 0 {
+		c.Header("Vary", "User-Agent, Accept-Language")
+		if longUrl, ok := route(link.Settings.Routes, v); ok {
+			return longUrl, Click{Variant: longUrl, Country: v.country}
+		}
+	}
+
+	destinations := link.Settings.Destinations
+	if len(destinations) == 0 {
+		return link.LongUrl, Click{Country: v.country}
+	}
+
+	shortUrl := encodeBase62(link.Id)
+	if link.Settings.Sticky {
+		if key, err := c.Cookie(variantCookiePrefix + shortUrl); err == nil {
+			for _, d := range destinations {
+				if d.key() == key {
+					return d.URL, Click{Variant: d.URL, Country: v.country}
+				}
+			}
+		}
+	}
+
+	// A returning visitor whose destination was since removed is assigned a new one
+	d := s.variants.pick(destinations)
+	if link.Settings.Sticky {
+		secure := strings.HasPrefix(s.baseURL, "https://")
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(variantCookiePrefix+shortUrl, d.key(), int(variantCookieTTL.Seconds()), cookiePath(c), "", secure, true)
+	}
+	return d.URL, Click{Variant: d.URL, Country: v.country}
+}
+
+// handleStats reports a link's clicks. Variants are keyed by destination, so they're left
+// out for links whose destinations are withheld, unless asked for by an admin.
+func (s *server) handleStats() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, err := s.isAdmin(c)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		link, err := s.app.lookup(c.Param("code"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !link.Exists() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "shortUrl not known"})
+			return
+		}
+		breakdown, err := s.db.ClickBreakdown(link.Id)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if !admin && link.Withheld(time.Now()) {
+			breakdown.Variants = map[string]uint64{}
+		}
+		c.JSON(http.StatusOK, gin.H{"clicks": link.Clicks, "variants": breakdown.Variants, "countries": breakdown.Countries})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"testing"
+)
+
+const rotatingLink = `{"destinations": [{"url": "https://a.example.com", "weight": 3}, {"url": "https://b.example.com", "weight": 1}], "sticky": %t}`
+
+func shortenRotatingForTest(t *testing.T, s *server, sticky bool) string {
+	t.Helper()
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", fmt.Sprintf(rotatingLink, sticky))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	return decodeBody(t, rec)["shortUrl"]
+}
+
+func TestVariantPicker_Weights(t *testing.T) {
+	vp := &variantPicker{rnd: rand.New(rand.NewSource(1))}
+	destinations := []Destination{{URL: "a", Weight: 3}, {URL: "b", Weight: 1}, {URL: "c", Weight: 0}}
+
+	counts := map[string]int{}
+	for i := 0; i < 5000; i++ {
+		counts[vp.pick(destinations).URL]++
+	}
+	// Expect 3000, 1000 and 1000
+	if counts["a"] < 2800 || counts["a"] > 3200 || counts["b"] < 850 || counts["b"] > 1150 || counts["c"] < 850 || counts["c"] > 1150 {
+		t.Errorf("Picks don't follow the weights: %v", counts)
+	}
+}
+
+func TestServer_Follow_Rotation(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenRotatingForTest(t, s, false)
+
+	seen := map[string]int{}
+	for i := 0; i < 200; i++ {
+		rec := doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected 302, got %d", rec.Code)
+		}
+		seen[rec.Header().Get("Location")]++
+	}
+	if len(seen) != 2 || seen["https://a.example.com"] <= seen["https://b.example.com"] {
+		t.Errorf("Visits should be split by weight, got %v", seen)
+	}
+
+	rec := doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/stats", nil)
+	var stats struct {
+		Clicks   uint64            `json:"clicks"`
+		Variants map[string]uint64 `json:"variants"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Clicks != 200 || stats.Variants["https://a.example.com"] != uint64(seen["https://a.example.com"]) ||
+		stats.Variants["https://b.example.com"] != uint64(seen["https://b.example.com"]) {
+		t.Errorf("Stats should attribute every click to its variant, got %+v for %v", stats, seen)
+	}
+}
+
+func TestServer_Follow_RotationSticky(t *testing.T) {
+	s := newTestServer(t)
+	shortUrl := shortenRotatingForTest(t, s, true)
+
+	first := doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	cookies := first.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != variantCookiePrefix+shortUrl {
+		t.Fatalf("Expected a variant cookie, got %v", cookies)
+	}
+
+	header := http.Header{"Cookie": {cookies[0].String()}}
+	for i := 0; i < 20; i++ {
+		rec := doRequest(s, http.MethodGet, "/"+shortUrl, header)
+		if rec.Header().Get("Location") != first.Header().Get("Location") {
+			t.Fatal("A returning visitor should get the same destination")
+		}
+	}
+}
+
+func TestServer_Shorten_InvalidDestinations(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"destinations": [{"url": "https://a.example.com"}, {"url": "https://a.example.com"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a repeated destination, got %d", rec.Code)
+	}
+}
+
+func TestServer_Stats_VariantsWithheld(t *testing.T) {
+	s := newTestServer(t)
+	s.adminToken = []byte("secret")
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "maxClicks": 5, "destinations": [{"url": "https://a.example.com"}]}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+	doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+
+	for header, expected := range map[string]int{"": 0, "Bearer secret": 1} {
+		rec = doRequest(s, http.MethodGet, "/api/v1/links/"+shortUrl+"/stats", http.Header{"Authorization": {header}})
+		var stats struct {
+			Clicks   uint64            `json:"clicks"`
+			Variants map[string]uint64 `json:"variants"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Clicks != 1 || len(stats.Variants) != expected {
+			t.Errorf("Expected %d variants with %q, got %+v", expected, header, stats)
+		}
+	}
+}
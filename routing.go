@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxRoutes = 20
+
+// Route sends visitors matching every condition it sets to URL instead of the link's
+// usual destination. Conditions left empty match anyone.
+type Route struct {
+	OS       string `json:"os,omitempty"`       // ios, android, windows, macos, linux or chromeos
+	Device   string `json:"device,omitempty"`   // mobile, tablet or desktop
+	Browser  string `json:"browser,omitempty"`  // chrome, safari, firefox, edge, opera or samsung
+	Language string `json:"language,omitempty"` // e.g. "de" or "pt-BR", compared with the preferred language
+	Country  string `json:"country,omitempty"`  // ISO 3166-1 code such as "US", needs a GeoIP database
+	Region   string `json:"region,omitempty"`   // ISO 3166-2 code such as "US-CA", needs a GeoIP database
+	URL      string `json:"url"`
+}
+
+var countryCode = regexp.MustCompile(`^[A-Za-z]{2}$`)
+var regionCode = regexp.MustCompile(`^[A-Za-z]{2}-[A-Za-z0-9]{1,3}$`)
+
+var routeValues = map[string][]string{
+	"os":      {"ios", "android", "windows", "macos", "linux", "chromeos"},
+	"device":  {"mobile", "tablet", "desktop"},
+	"browser": {"chrome", "safari", "firefox", "edge", "opera", "samsung"},
+}
+
+func validateRoutes(routes []Route) error {
+	if len(routes) > maxRoutes {
+		return fmt.Errorf("at most %d routes are allowed", maxRoutes)
+	}
+	for i, r := range routes {
+		if r.URL == "" {
+			return fmt.Errorf("route %d needs a `url`", i)
+		}
+		for field, value := range map[string]string{"os": r.OS, "device": r.Device, "browser": r.Browser} {
+			if value != "" && !containsString(routeValues[field], value) {
+				return fmt.Errorf("route %d has unknown %s %q, expected one of %s", i, field, value, strings.Join(routeValues[field], ", "))
+			}
+		}
+		if r.Country != "" && !countryCode.MatchString(r.Country) {
+			return fmt.Errorf("route %d has malformed country %q, expected a code such as US", i, r.Country)
+		}
+		if r.Region != "" && !regionCode.MatchString(r.Region) {
+			return fmt.Errorf("route %d has malformed region %q, expected a code such as US-CA", i, r.Region)
+		}
+	}
+	return nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// visitor is what routes can tell apart about whoever follows a link
+type visitor struct {
+	os       string
+	device   string
+	browser  string
+	language string // Most preferred, lower case, empty if not given
+	location
+}
+
+func (s *server) visitor(c *gin.Context) visitor {
+	v := parseUserAgent(c.GetHeader("User-Agent"))
+	v.language = preferredLanguage(c.GetHeader("Accept-Language"))
+	if s.geo != nil {
+		if ip := net.ParseIP(c.ClientIP()); ip != nil {
+			loc, err := s.geo.locate(ip)
+			if err != nil {
+				_ = c.Error(err) // Route and count the visit as if it weren't located
+			}
+			v.location = loc
+		}
+	}
+	return v
+}
+
+// parseUserAgent recognizes the common platforms and browsers. It only has to be good
+// enough to route visitors, anything unrecognized is left empty and falls through.
+func parseUserAgent(ua string) visitor {
+	var v visitor
+	switch {
+	case strings.Contains(ua, "iPad"):
+		v.os, v.device = "ios", "tablet"
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPod"):
+		v.os, v.device = "ios", "mobile"
+	case strings.Contains(ua, "Android"):
+		v.os, v.device = "android", "tablet"
+		if strings.Contains(ua, "Mobile") { // Android phones say Mobile, tablets don't
+			v.device = "mobile"
+		}
+	case strings.Contains(ua, "CrOS"):
+		v.os, v.device = "chromeos", "desktop"
+	case strings.Contains(ua, "Windows"):
+		v.os, v.device = "windows", "desktop"
+	case strings.Contains(ua, "Macintosh"):
+		v.os, v.device = "macos", "desktop"
+	case strings.Contains(ua, "Linux"):
+		v.os, v.device = "linux", "desktop"
+	}
+
+	// Most browsers claim to be the ones before them, so check the most specific first
+	switch {
+	case strings.Contains(ua, "SamsungBrowser/"):
+		v.browser = "samsung"
+	case strings.Contains(ua, "Edg/"), strings.Contains(ua, "EdgA/"), strings.Contains(ua, "EdgiOS/"):
+		v.browser = "edge"
+	case strings.Contains(ua, "OPR/"), strings.Contains(ua, "OPiOS/"):
+		v.browser = "opera"
+	case strings.Contains(ua, "Firefox/"), strings.Contains(ua, "FxiOS/"):
+		v.browser = "firefox"
+	case strings.Contains(ua, "Chrome/"), strings.Contains(ua, "CriOS/"):
+		v.browser = "chrome"
+	case strings.Contains(ua, "Safari/"):
+		v.browser = "safari"
+	}
+	return v
+}
+
+// preferredLanguage returns the language tag with the highest quality in an
+// Accept-Language header, the first listed on a tie
+func preferredLanguage(header string) string {
+	type tag struct {
+		name    string
+		quality float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" || name == "*" {
+			continue
+		}
+		quality := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			tags = append(tags, tag{strings.ToLower(name), quality})
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+	return tags[0].name
+}
+
+func (r Route) matches(v visitor) bool {
+	if r.OS != "" && r.OS != v.os {
+		return false
+	}
+	if r.Device != "" && r.Device != v.device {
+		return false
+	}
+	if r.Browser != "" && r.Browser != v.browser {
+		return false
+	}
+	if r.Country != "" && !strings.EqualFold(r.Country, v.country) {
+		return false
+	}
+	if r.Region != "" && !strings.EqualFold(r.Region, v.region) {
+		return false
+	}
+	if r.Language != "" {
+		// "pt" matches "pt-BR", but "pt-BR" doesn't match "pt"
+		lang := strings.ToLower(r.Language)
+		if v.language != lang && !strings.HasPrefix(v.language, lang+"-") {
+			return false
+		}
+	}
+	return true
+}
+
+// route returns the URL of the first of routes that matches the visitor, if any does
+func route(routes []Route, v visitor) (string, bool) {
+	for _, r := range routes {
+		if r.matches(v) {
+			return r.URL, true
+		}
+	}
+	return "", false
+}
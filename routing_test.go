@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	iPhoneSafari   = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	androidChrome  = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36"
+	androidTablet  = "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36"
+	windowsEdge    = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0"
+	macFirefox     = "Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0"
+	linuxChrome    = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	unknownVisitor = "curl/8.4.0"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua   string
+		want visitor
+	}{
+		{iPhoneSafari, visitor{os: "ios", device: "mobile", browser: "safari"}},
+		{androidChrome, visitor{os: "android", device: "mobile", browser: "chrome"}},
+		{androidTablet, visitor{os: "android", device: "tablet", browser: "samsung"}},
+		{windowsEdge, visitor{os: "windows", device: "desktop", browser: "edge"}},
+		{macFirefox, visitor{os: "macos", device: "desktop", browser: "firefox"}},
+		{linuxChrome, visitor{os: "linux", device: "desktop", browser: "chrome"}},
+		{unknownVisitor, visitor{}},
+	}
+	for _, test := range tests {
+		if got := parseUserAgent(test.ua); got != test.want {
+			t.Errorf("parseUserAgent(%q) = %+v, want %+v", test.ua, got, test.want)
+		}
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"de-DE":                     "de-de",
+		"fr;q=0.5, en-US, en;q=0.8": "en-us",
+		"*, pt-BR;q=0.9":            "pt-br",
+		"es;q=0, it;q=0.1":          "it",
+	}
+	for header, want := range tests {
+		if got := preferredLanguage(header); got != want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestServer_Follow_Routes(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com/app", "routes": [
+		{"os": "ios", "url": "https://apps.apple.com/app/id1"},
+		{"os": "android", "url": "https://play.google.com/store/apps/details?id=app"},
+		{"language": "de", "url": "https://example.com/de/app"}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"User-Agent": {iPhoneSafari}}, "https://apps.apple.com/app/id1"},
+		{http.Header{"User-Agent": {androidChrome}, "Accept-Language": {"de"}}, "https://play.google.com/store/apps/details?id=app"},
+		{http.Header{"User-Agent": {windowsEdge}, "Accept-Language": {"de-AT, en;q=0.5"}}, "https://example.com/de/app"},
+		{http.Header{"User-Agent": {macFirefox}}, "https://example.com/app"},
+	}
+	for _, test := range tests {
+		rec := doRequest(s, http.MethodGet, "/"+shortUrl, test.header)
+		if got := rec.Header().Get("Location"); got != test.want {
+			t.Errorf("Visitor with %v sent to %s, want %s", test.header, got, test.want)
+		}
+		if rec.Header().Get("Vary") == "" {
+			t.Error("Routed redirects should vary on the headers they are routed by")
+		}
+	}
+}
+
+func TestServer_Shorten_InvalidRoute(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "routes": [{"os": "symbian", "url": "https://example.com/s60"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown OS, got %d", rec.Code)
+	}
+}
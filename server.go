@@ -1,50 +1,201 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"time"
 )
 
 type server struct {
-	routes *gin.Engine
-	app    *URLShortenerApp
-	db     UrlDB
+	routes       *gin.Engine
+	app          *URLShortenerApp
+	db           UrlDB
+	idempotency  *idempotencyStore
+	baseURL      string
+	cookieSecret []byte
+	unlockTTL    time.Duration
+	unlocks      *unlockThrottle
+	variants     *variantPicker
+	geo          *geoIP // Nil unless a GeoIP database is configured
+	adminToken   []byte
 }
 
-func newServer(r *gin.Engine, app *URLShortenerApp, db UrlDB) (*server, error) {
+func newServer(r *gin.Engine, app *URLShortenerApp, db UrlDB, cfg config) (*server, error) {
 	s := &server{
-		routes: r,
-		app:    app,
-		db:     db,
+		routes:       r,
+		app:          app,
+		db:           db,
+		idempotency:  newIdempotencyStore(cfg.idempotencyWindow),
+		baseURL:      cfg.baseURL,
+		cookieSecret: cfg.cookieSecret,
+		unlockTTL:    cfg.unlockTTL,
+		unlocks:      newUnlockThrottle(),
+		variants:     newVariantPicker(),
+		adminToken:   cfg.adminToken,
+	}
+	// Otherwise gin trusts X-Forwarded-For from anyone, letting visitors pick their country
+	if err := r.SetTrustedProxies(cfg.trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	if cfg.geoIPPath != "" {
+		geo, err := newGeoIP(cfg.geoIPPath, openMMDB)
+		if err != nil {
+			return nil, err
+		}
+		geo.watch(cfg.geoIPReload)
+		s.geo = geo
 	}
 	s.addRoutes()
 	return s, nil
 }
 
+// addRoutes adds the routes of the server. Top level paths that could be short URLs are
+// in reservedCodes.
 func (s *server) addRoutes() {
-	s.routes.GET("api/v1/health", s.handleHealth())
-	s.routes.POST("api/v1/shorten", s.handleShorten())
+	s.routes.GET("livez", s.handleLive())
+	s.routes.GET("readyz", s.handleReady())
+	s.routes.GET("api/v1/health", s.handleReady())
+	s.routes.POST("api/v1/shorten", s.idempotent(), s.handleShorten())
 	s.routes.GET("api/v1/redirect", s.handleRedirect())
+	s.routes.GET("api/v1/links/:code/qr", s.handleQR())
+	s.routes.GET("api/v1/links/:code/stats", s.handleStats())
+	s.routes.GET("api/v1/links/:code/info", s.handleInfo())
+	s.routes.GET("api/v1/links", s.handleListLinks())
+	s.routes.POST("api/v1/links/import", s.adminOnly(), s.handleImport())
+	s.routes.GET("api/v1/links/export", s.adminOnly(), s.handleExport())
+	s.routes.PATCH("api/v1/links/:code", s.adminOnly(), s.handleUpdateLink())
+	s.routes.DELETE("api/v1/links/:code", s.adminOnly(), s.handleDeleteLink())
+	s.routes.GET("api/v1/links/:code/history", s.adminOnly(), s.handleHistory())
+	s.routes.POST("api/v1/links/:code/history/:version/rollback", s.adminOnly(), s.handleRollback())
+	s.routes.PUT("api/v1/campaigns/:name", s.handlePutCampaign())
+	s.routes.GET("api/v1/campaigns/:name", s.handleGetCampaign())
+	s.routes.GET(":code", s.handleFollow())
+	s.routes.GET(":code/*path", s.handleFollow())
+	s.routes.POST(":code", s.handleUnlock())
+	s.routes.POST(":code/*path", s.handleUnlock())
 }
 
-func (s *server) handleHealth() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if !s.db.Connected() {
-			c.JSON(http.StatusInternalServerError, gin.H{"status": "error"})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+// publicURL is the address users visit to be redirected by shortUrl
+func (s *server) publicURL(shortUrl string) string {
+	return s.baseURL + "/" + shortUrl
+}
+
+// shortenRequest is accepted as query params, a form or a JSON body
+type shortenRequest struct {
+	LongUrl      string        `form:"longUrl" json:"longUrl"`
+	Interstitial bool          `form:"interstitial" json:"interstitial"`
+	Password     string        `form:"password" json:"password"` // Prefer the body, query strings get logged
+	MaxClicks    uint64        `form:"maxClicks" json:"maxClicks"`
+	Destinations []Destination `form:"-" json:"destinations"` // Only accepted as JSON
+	Sticky       bool          `form:"sticky" json:"sticky"`
+	Routes       []Route       `form:"-" json:"routes"` // Only accepted as JSON
+	Passthrough  bool          `form:"passthrough" json:"passthrough"`
+	UTM          UTM           `json:"utm"`                      // As JSON, or utm_source etc. as params
+	Campaign     string        `form:"campaign" json:"campaign"` // Saved UTM parameters, for those not given
+	NotBefore    *time.Time    `form:"notBefore" json:"notBefore" time_format:"2006-01-02T15:04:05Z07:00"`
+	NotAfter     *time.Time    `form:"notAfter" json:"notAfter" time_format:"2006-01-02T15:04:05Z07:00"`
+	PendingURL   string        `form:"pendingUrl" json:"pendingUrl"`
+	Title        string        `form:"title" json:"title"`
+	Notes        string        `form:"notes" json:"notes"`
+	Tags         []string      `form:"tags" json:"tags"` // Repeat the param for several as a query or form
+}
+
+func (req shortenRequest) settings() LinkSettings {
+	return LinkSettings{
+		Interstitial: req.Interstitial,
+		MaxClicks:    req.MaxClicks,
+		Destinations: req.Destinations,
+		Sticky:       req.Sticky,
+		Routes:       req.Routes,
+		Passthrough:  req.Passthrough,
+		NotBefore:    windowTime(req.NotBefore),
+		NotAfter:     windowTime(req.NotAfter),
+		PendingURL:   req.PendingURL,
+		Title:        req.Title,
+		Notes:        req.Notes,
+		Tags:         normalizeTags(req.Tags),
 	}
 }
 
+// windowTime normalizes t so that the same window given twice compares as equal
+func windowTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	normalized := t.UTC().Truncate(time.Second)
+	return &normalized
+}
+
+func validateWindow(settings LinkSettings) error {
+	if settings.NotBefore != nil && settings.NotAfter != nil && !settings.NotAfter.After(*settings.NotBefore) {
+		return errors.New("`notAfter` must be later than `notBefore`")
+	}
+	if settings.PendingURL != "" && settings.NotBefore == nil {
+		return errors.New("`pendingUrl` needs a `notBefore`")
+	}
+	return nil
+}
+
 func (s *server) handleShorten() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		longUrl := c.Query("longUrl")
-		if longUrl == "" {
+		var req shortenRequest
+		if err := c.ShouldBind(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "malformed request: " + err.Error()})
+			return
+		}
+		if err := validateDestinations(req.Destinations); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateRoutes(req.Routes); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.LongUrl == "" && len(req.Destinations) > 0 {
+			req.LongUrl = req.Destinations[0].URL // Identifies the link, e.g. for deduplication
+		}
+		if req.LongUrl == "" {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "param `longUrl` is required"})
 			return
 		}
-		shortUrl, err := s.app.shorten(longUrl)
+
+		settings := req.settings()
+		if err := validateDescription(settings); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateWindow(settings); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validatePassword(req.Password); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		utm, err := s.app.resolveUTM(req.Campaign, req.UTM)
+		if errors.Is(err, ErrUnknownCampaign) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if err = validateUTM(utm); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !utm.IsZero() {
+			settings.UTM = &utm
+		}
+
+		shortUrl, err := s.app.shortenWithSettings(req.LongUrl, settings, req.Password)
+		if errors.Is(err, ErrSettingsConflict) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
@@ -61,18 +212,48 @@ func (s *server) handleRedirect() gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "param `shortUrl` is required"})
 			return
 		}
-		longUrl, err := s.app.redirect(shortUrl)
+		link, err := s.app.lookup(shortUrl)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal server error"})
 			return
 		}
 
-		if longUrl == "" {
+		if !link.Exists() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "shortUrl not known"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"longUrl": longUrl})
+		if link.Settings.PasswordHash != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "shortUrl is password protected"})
+			return
+		}
+
+		switch link.window(time.Now()) {
+		case windowPending:
+			c.JSON(http.StatusNotFound, gin.H{"error": "shortUrl is not active yet", "notBefore": link.Settings.NotBefore})
+			return
+		case windowClosed:
+			c.JSON(http.StatusGone, gin.H{"error": "shortUrl is no longer active"})
+			return
+		}
+
+		if link.Exhausted() {
+			c.JSON(http.StatusGone, gin.H{"error": "shortUrl has no clicks left"})
+			return
+		}
+		if link.Limited() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "shortUrl has a click limit, so it can only be followed"})
+			return
+		}
+
+		resp := gin.H{"longUrl": link.LongUrl}
+		if len(link.Settings.Destinations) > 0 {
+			resp["destinations"] = link.Settings.Destinations
+		}
+		if len(link.Settings.Routes) > 0 {
+			resp["routes"] = link.Settings.Routes
+		}
+		c.JSON(http.StatusOK, resp)
 	}
 }
 
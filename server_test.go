@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testAdminToken is the admin token of servers made by newTestServer
+const testAdminToken = "admin"
+
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db := &InMemoryUrlDb{records: make([]InMemoryUrlDbRecord, 0)}
+	app := &URLShortenerApp{
+		urlRepo:     db,
+		idGenerator: newUniqueIDGenerator(),
+	}
+	s, err := newServer(gin.New(), app, db, defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.adminToken = []byte(testAdminToken)
+	return s
+}
+
+func asAdmin() http.Header {
+	return http.Header{"Authorization": {"Bearer " + testAdminToken}}
+}
+
+func doRequest(s *server, method string, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	body := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response body is not JSON: %q", rec.Body.String())
+	}
+	return body
+}
+
+func shortenForTest(t *testing.T, s *server, longUrl string) string {
+	t.Helper()
+	rec := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl="+longUrl, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d", rec.Code)
+	}
+	return decodeBody(t, rec)["shortUrl"]
+}
+
+func TestServer_Shorten(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=www.google.com", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if len(decodeBody(t, rec)["shortUrl"]) != shortURLLen {
+		t.Error("Shorten should respond with a short URL of the correct length")
+	}
+}
+
+func TestServer_Shorten_MissingLongURL(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doRequest(s, http.MethodPost, "/api/v1/shorten", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
+
+func TestServer_Shorten_IdempotencyKeyReplays(t *testing.T) {
+	s := newTestServer(t)
+	header := http.Header{idempotencyHeader: {"key-1"}}
+
+	first := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=www.google.com", header)
+	second := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=www.google.com", header)
+
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("Expected 200s, got %d and %d", first.Code, second.Code)
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Error("Replayed response should match the original")
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Only the replayed response should be marked as replayed")
+	}
+}
+
+func TestServer_Shorten_IdempotencyKeyReusedForDifferentRequest(t *testing.T) {
+	s := newTestServer(t)
+	header := http.Header{idempotencyHeader: {"key-1"}}
+
+	doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=www.google.com", header)
+	rec := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=www.bing.com", header)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d", rec.Code)
+	}
+}
+
+func TestServer_Redirect(t *testing.T) {
+	s := newTestServer(t)
+
+	shortUrl := decodeBody(t, doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=www.google.com", nil))["shortUrl"]
+	rec := doRequest(s, http.MethodGet, "/api/v1/redirect?shortUrl="+shortUrl, nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if decodeBody(t, rec)["longUrl"] != "www.google.com" {
+		t.Error("Redirect should respond with the original long URL")
+	}
+}
+
+func doJSONRequest(s *server, method string, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServer_Shorten_JSONBody(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "www.google.com", "interstitial": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	link, err := s.app.lookup(decodeBody(t, rec)["shortUrl"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !link.Settings.Interstitial {
+		t.Error("Settings given in the body should be stored with the link")
+	}
+}
+
+func TestServer_Shorten_SettingsConflict(t *testing.T) {
+	s := newTestServer(t)
+
+	shortenForTest(t, s, "www.google.com")
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "www.google.com", "interstitial": true}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409, got %d", rec.Code)
+	}
+}
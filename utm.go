@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// ErrUnknownCampaign is returned when shortening with a campaign that wasn't saved
+var ErrUnknownCampaign = errors.New("no campaign with that name")
+
+const maxUTMValueLen = 255
+
+var campaignName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// UTM holds the analytics parameters added to a link's destination when it's followed
+type UTM struct {
+	Source   string `form:"utm_source" json:"source,omitempty"`
+	Medium   string `form:"utm_medium" json:"medium,omitempty"`
+	Campaign string `form:"utm_campaign" json:"campaign,omitempty"`
+	Term     string `form:"utm_term" json:"term,omitempty"`
+	Content  string `form:"utm_content" json:"content,omitempty"`
+}
+
+func (u UTM) IsZero() bool {
+	return u == UTM{}
+}
+
+func (u UTM) values() url.Values {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"utm_source":   u.Source,
+		"utm_medium":   u.Medium,
+		"utm_campaign": u.Campaign,
+		"utm_term":     u.Term,
+		"utm_content":  u.Content,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values
+}
+
+// withDefaults fills the parameters u leaves empty from defaults
+func (u UTM) withDefaults(defaults UTM) UTM {
+	for _, field := range []struct{ value, fallback *string }{
+		{&u.Source, &defaults.Source},
+		{&u.Medium, &defaults.Medium},
+		{&u.Campaign, &defaults.Campaign},
+		{&u.Term, &defaults.Term},
+		{&u.Content, &defaults.Content},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+	return u
+}
+
+func validateUTM(u UTM) error {
+	if u.IsZero() {
+		return nil
+	}
+	if u.Source == "" {
+		return fmt.Errorf("UTM parameters need a source")
+	}
+	for _, value := range u.values() {
+		if len(value[0]) > maxUTMValueLen {
+			return fmt.Errorf("UTM parameters must be at most %d characters", maxUTMValueLen)
+		}
+	}
+	return nil
+}
+
+// Campaign is a saved set of UTM parameters that links can be shortened with
+type Campaign struct {
+	Name string `json:"name"`
+	UTM  UTM    `json:"utm"`
+}
+
+// resolveUTM combines the parameters given for a link with those of the named campaign,
+// which fill in any left out. The campaign is copied into the link, so editing it
+// later only affects links shortened after.
+func (app *URLShortenerApp) resolveUTM(campaign string, utm UTM) (UTM, error) {
+	if campaign == "" {
+		return utm, nil
+	}
+	saved, err := app.urlRepo.GetCampaign(campaign)
+	if err != nil {
+		return UTM{}, err
+	}
+	if saved.Name == "" {
+		return UTM{}, fmt.Errorf("%w: %s", ErrUnknownCampaign, campaign)
+	}
+	return utm.withDefaults(saved.UTM), nil
+}
+
+// tagDestination adds a link's UTM parameters to where it's sending the visitor.
+// Parameters the destination already has are left as they are.
+func tagDestination(destination string, utm *UTM) (string, error) {
+	if utm == nil {
+		return destination, nil
+	}
+	return addQuery(destination, utm.values())
+}
+
+func (s *server) handlePutCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name := c.Param("name")
+		if !campaignName.MatchString(name) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "campaign names are 1 to 64 letters, digits, - or _"})
+			return
+		}
+		var utm UTM
+		if err := c.ShouldBindJSON(&utm); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "malformed request: " + err.Error()})
+			return
+		}
+		if utm.IsZero() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "a campaign needs UTM parameters"})
+			return
+		}
+		if err := validateUTM(utm); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		campaign := Campaign{Name: name, UTM: utm}
+		if err := s.db.StoreCampaign(campaign); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.JSON(http.StatusOK, campaign)
+	}
+}
+
+func (s *server) handleGetCampaign() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		campaign, err := s.db.GetCampaign(c.Param("name"))
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		if campaign.Name == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "campaign not known"})
+			return
+		}
+		c.JSON(http.StatusOK, campaign)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTagDestination(t *testing.T) {
+	utm := &UTM{Source: "newsletter", Medium: "email"}
+
+	got, err := tagDestination("https://example.com/launch?utm_medium=print&ref=1", utm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://example.com/launch?ref=1&utm_medium=print&utm_source=newsletter"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestServer_Follow_UTM(t *testing.T) {
+	s := newTestServer(t)
+	rec := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=https://example.com/launch&utm_source=newsletter&utm_campaign=spring", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	if got := rec.Header().Get("Location"); got != "https://example.com/launch?utm_campaign=spring&utm_source=newsletter" {
+		t.Errorf("Expected the destination to be tagged, got %s", got)
+	}
+
+	link, _ := s.app.lookup(shortUrl)
+	if link.LongUrl != "https://example.com/launch" {
+		t.Errorf("Tagging should leave the long URL alone, got %s", link.LongUrl)
+	}
+}
+
+func TestServer_Shorten_Campaign(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPut, "/api/v1/campaigns/spring-launch", `{"source": "press", "medium": "release", "campaign": "spring"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 saving the campaign, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com/launch", "campaign": "spring-launch", "utm": {"medium": "blog"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl, nil)
+	if got := rec.Header().Get("Location"); got != "https://example.com/launch?utm_campaign=spring&utm_medium=blog&utm_source=press" {
+		t.Errorf("Expected the campaign's parameters, overridden by the link's, got %s", got)
+	}
+}
+
+func TestServer_Shorten_UnknownCampaign(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "campaign": "nope"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown campaign, got %d", rec.Code)
+	}
+}
+
+func TestServer_Follow_UTMNotOverriddenByPassthrough(t *testing.T) {
+	s := newTestServer(t)
+	rec := doJSONRequest(s, http.MethodPost, "/api/v1/shorten", `{"longUrl": "https://example.com", "passthrough": true, "utm": {"source": "qr"}}`)
+	shortUrl := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodGet, "/"+shortUrl+"?utm_source=spoofed&page=2", nil)
+	if got := rec.Header().Get("Location"); got != "https://example.com?page=2&utm_source=qr" {
+		t.Errorf("Expected the link's tags to win over the visitor's, got %s", got)
+	}
+}
+
+func TestServer_Shorten_OtherCampaign(t *testing.T) {
+	s := newTestServer(t)
+	rec := doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=https://example.com/launch&utm_source=newsletter&utm_campaign=spring", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from shorten, got %d: %s", rec.Code, rec.Body.String())
+	}
+	spring := decodeBody(t, rec)["shortUrl"]
+
+	rec = doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=https://example.com/launch&utm_source=newsletter&utm_campaign=autumn", nil)
+	if rec.Code != http.StatusConflict || decodeBody(t, rec)["error"] != ErrUTMConflict.Error() {
+		t.Fatalf("Expected 409 explaining campaigns, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(s, http.MethodPost, "/api/v1/shorten?longUrl=https://example.com/launch%3Futm_campaign%3Dautumn", nil)
+	if rec.Code != http.StatusOK || decodeBody(t, rec)["shortUrl"] == spring {
+		t.Fatalf("Expected a link of its own for the tagged long URL, got %d: %s", rec.Code, rec.Body.String())
+	}
+}